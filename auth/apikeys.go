@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"apiForSN/models"
+	"apiForSN/repository"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Все ключи API начинаются с этого префикса, по нему middleware отличает их от JWT
+const APIKeyPrefix = "snk_"
+
+// Как часто обновляется время последнего использования ключа. Чаще писать в базу нет смысла
+const apiKeyLastUsedResolution = time.Minute
+
+// ErrInvalidAPIKey возвращается для неизвестного, отозванного или истёкшего ключа
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// IsAPIKey сообщает, похожа ли строка на ключ API
+func IsAPIKey(value string) bool {
+	return strings.HasPrefix(value, APIKeyPrefix)
+}
+
+// GenerateAPIKey создаёт ключ вида snk_<prefix>_<secret>. Префикс хранится открыто, чтобы пользователь мог узнать ключ в списке
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(prefixBytes)
+	key = APIKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, HashOpaqueToken(key), nil
+}
+
+// AuthenticateAPIKey находит действующий ключ и возвращает его вместе с эффективными областями доступа:
+// пересечением областей ключа и текущих прав владельца
+func AuthenticateAPIKey(key string) (*models.APIKey, []string, error) {
+	apiKey, err := storage.APIKeys.GetByHash(HashOpaqueToken(key))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	owner, err := storage.Users.GetByID(apiKey.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	ownerScopes := ScopesForRoles(owner.Roles)
+	var scopes []string
+	for _, scope := range apiKey.Scopes {
+		if HasScope(ownerScopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	// Время последнего использования обновляем не чаще раза в минуту
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyLastUsedResolution {
+		if err := storage.APIKeys.TouchLastUsed(apiKey.ID, now); err != nil {
+			return nil, nil, err
+		}
+		apiKey.LastUsedAt = &now
+	}
+	return apiKey, scopes, nil
+}
+
+// APIKeyScopeAllowed сообщает, можно ли выдать ключу область доступа. Управление аккаунтом ключам недоступно
+func APIKeyScopeAllowed(scope string, ownerRoles []string) bool {
+	return scope != ScopeAccount && HasScope(ScopesForRoles(ownerRoles), scope)
+}
@@ -1,36 +1,198 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// Секретный ключ для подписи токенов. Его нужно хранить в защищенном месте (например, в переменной окружения).
-var JwtKey = []byte(os.Getenv("JWT_SECRET_KEY"))
+// Параметры токенов по умолчанию
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	defaultIssuer          = "apiForSN"
+	defaultAudience        = "apiForSN"
+	defaultLeeway          = 30 * time.Second
+)
+
+// Issuer возвращает издателя токенов (claim iss, переменная окружения JWT_ISSUER)
+func Issuer() string {
+	return stringFromEnv("JWT_ISSUER", defaultIssuer)
+}
+
+// Audience возвращает аудиторию токенов (claim aud, переменная окружения JWT_AUDIENCE)
+func Audience() string {
+	return stringFromEnv("JWT_AUDIENCE", defaultAudience)
+}
+
+// Leeway возвращает допустимое расхождение часов при проверке exp, nbf и iat (переменная окружения JWT_LEEWAY)
+func Leeway() time.Duration {
+	return durationFromEnv("JWT_LEEWAY", defaultLeeway)
+}
+
+// AccessTokenTTL возвращает время жизни access-токена (переменная окружения JWT_ACCESS_TTL, например "15m")
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("JWT_ACCESS_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL возвращает время жизни refresh-токена (переменная окружения JWT_REFRESH_TTL, например "720h")
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("JWT_REFRESH_TTL", defaultRefreshTokenTTL)
+}
+
+// Типы токенов (claim typ). Middleware принимает только access-токены
+const (
+	TokenTypeAccess     = "access"
+	TokenTypeMFAPending = "mfa_pending"
+)
+
+// Время жизни промежуточного токена, выдаваемого после пароля при включённой 2FA
+const mfaTokenTTL = 5 * time.Minute
 
 // Структура данных для токена
 type Claims struct {
-	UserID int `json:"user_id"`
+	UserID    int      `json:"user_id"`
+	TokenType string   `json:"typ"`
+	Roles     []string `json:"roles,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// Функция генерации токена
-func GenerateJWT(userID int) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Устанавливаем срок действия токена на 24 часа
+// Функция генерации короткоживущего access-токена, привязанного к сессии. Возвращает подписанный токен и момент его истечения
+func GenerateJWT(userID int, roles []string, sessionID string) (string, time.Time, error) {
+	return generateToken(userID, roles, sessionID, TokenTypeAccess, AccessTokenTTL())
+}
+
+// GenerateMFAToken выпускает промежуточный токен «ожидается второй фактор». Он не даёт доступа к API
+// и только обменивается вместе с TOTP-кодом на полноценные токены
+func GenerateMFAToken(userID int) (string, time.Time, error) {
+	return generateToken(userID, nil, "", TokenTypeMFAPending, mfaTokenTTL)
+}
+
+func generateToken(userID int, roles []string, sessionID string, tokenType string, ttl time.Duration) (string, time.Time, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(ttl)
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		TokenType: tokenType,
+		Roles:     roles,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    Issuer(),
+			Audience:  jwt.ClaimStrings{Audience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JwtKey)
+	// Подписываем текущим ключом и указываем его kid, чтобы проверяющая сторона нашла нужный публичный ключ
+	key, err := signingKey()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expirationTime, nil
+}
+
+// Алгоритмы, которые принимаются при проверке токенов. Всё остальное, включая "none" и HS256, отклоняется
+var allowedAlgorithms = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+
+// ParseToken проверяет подпись токена ключом, выбранным по kid, а затем срок действия, издателя, аудиторию
+// с учётом допустимого расхождения часов и тип токена. Ошибки возвращаются как *TokenError с машиночитаемым кодом
+func ParseToken(tokenString string, tokenType string) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(allowedAlgorithms), jwt.WithoutClaimsValidation())
+	token, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := verificationKey(kid)
+		if !ok {
+			return nil, errUnknownKey
+		}
+		// Алгоритм токена должен совпадать с алгоритмом ключа, иначе возможна подмена алгоритма
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errAlgorithmMismatch
+		}
+		return key.Public, nil
+	})
+	if err != nil {
+		return nil, classifyParseError(token, err)
+	}
+	if !token.Valid {
+		return nil, newTokenError(CodeTokenMalformed, "Token is malformed")
+	}
+
+	if err := validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	if claims.TokenType != tokenType {
+		return nil, newTokenError(CodeTokenInvalidType, "Token has an unexpected type")
+	}
+	return claims, nil
+}
+
+// validateClaims проверяет временные claims с допуском на расхождение часов, а также iss и aud
+func validateClaims(claims *Claims, now time.Time) error {
+	leeway := Leeway()
+	if claims.ExpiresAt == nil {
+		return newTokenError(CodeTokenMalformed, "Token has no expiration time")
+	}
+	if !claims.VerifyExpiresAt(now.Add(-leeway), true) {
+		return newTokenError(CodeTokenExpired, "Token has expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(leeway), false) || !claims.VerifyIssuedAt(now.Add(leeway), false) {
+		return newTokenError(CodeTokenNotYetValid, "Token is not valid yet")
+	}
+	if !claims.VerifyIssuer(Issuer(), true) {
+		return newTokenError(CodeTokenInvalidIssuer, "Token has an invalid issuer")
+	}
+	if !claims.VerifyAudience(Audience(), true) {
+		return newTokenError(CodeTokenInvalidAudience, "Token has an invalid audience")
+	}
+	return nil
+}
+
+// newTokenID генерирует уникальный идентификатор токена (claim jti), по которому токен можно отозвать
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(buf), nil
+}
+
+// stringFromEnv читает строку из переменной окружения, при отсутствии возвращает значение по умолчанию
+func stringFromEnv(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
 
-	return tokenString, nil
+// durationFromEnv читает длительность из переменной окружения, при отсутствии или ошибке возвращает значение по умолчанию
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
 }
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newTestStorage подключает пакет к хранилищу в памяти с временным ключом подписи и возвращает id нового пользователя
+func newTestStorage(t *testing.T) int {
+	t.Helper()
+	if err := GenerateEphemeralKey(); err != nil {
+		t.Fatal(err)
+	}
+	repos := repository.NewMemory()
+	UseStorage(repos)
+	user := models.User{Nickname: "alice", Email: "alice@example.com"}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// signTestToken подписывает claims текущим ключом, заменяя перед этим поля через modify
+func signTestToken(t *testing.T, modify func(*Claims)) string {
+	t.Helper()
+	key, err := signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claims := &Claims{
+		UserID:    1,
+		TokenType: TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "test",
+			Issuer:    Issuer(),
+			Audience:  jwt.ClaimStrings{Audience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+	modify(claims)
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestParseTokenErrorCodes(t *testing.T) {
+	if err := GenerateEphemeralKey(); err != nil {
+		t.Fatal(err)
+	}
+	mfaToken, _, err := GenerateMFAToken(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HS256 с kid настоящего ключа: подмена алгоритма должна отклоняться до проверки подписи
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: 1, TokenType: TokenTypeAccess})
+	hmacToken.Header["kid"] = "ephemeral"
+	hmacSigned, err := hmacToken.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{"wrong alg", hmacSigned, CodeTokenUnsupportedAlgorithm},
+		{"wrong iss", signTestToken(t, func(c *Claims) { c.Issuer = "someone-else" }), CodeTokenInvalidIssuer},
+		{"wrong aud", signTestToken(t, func(c *Claims) { c.Audience = jwt.ClaimStrings{"someone-else"} }), CodeTokenInvalidAudience},
+		{"nbf in the future", signTestToken(t, func(c *Claims) {
+			c.NotBefore = jwt.NewNumericDate(time.Now().Add(Leeway() + time.Minute))
+		}), CodeTokenNotYetValid},
+		{"expired", signTestToken(t, func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-Leeway() - time.Minute))
+		}), CodeTokenExpired},
+		{"wrong typ", mfaToken, CodeTokenInvalidType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseToken(tt.token, TokenTypeAccess)
+			var tokenErr *TokenError
+			if !errors.As(err, &tokenErr) {
+				t.Fatalf("err = %v, want *TokenError", err)
+			}
+			if tokenErr.Code != tt.code {
+				t.Errorf("code = %s, want %s", tokenErr.Code, tt.code)
+			}
+		})
+	}
+
+	// Токен в пределах допуска на расхождение часов принимается
+	skewed := signTestToken(t, func(c *Claims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(Leeway() / 2)) })
+	if _, err := ParseToken(skewed, TokenTypeAccess); err != nil {
+		t.Errorf("token within leeway: %v", err)
+	}
+}
+
+func TestRefreshRotationAndReuse(t *testing.T) {
+	userID := newTestStorage(t)
+
+	first, err := IssueRefreshToken(userID, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := RotateRefreshToken(first.Token, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Token == first.Token || second.SessionID != first.SessionID {
+		t.Fatalf("rotation must issue a new token in the same session")
+	}
+
+	// Повторное предъявление погашенного токена отзывает всё семейство, включая уже выданный следующий токен
+	if _, err := RotateRefreshToken(first.Token, ClientInfo{}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("reuse: err = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := RotateRefreshToken(second.Token, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("token after reuse: err = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	access, _, err := GenerateJWT(userID, nil, first.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(access, TokenTypeAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := Revocations.IsRevoked(claims); err != nil || !revoked {
+		t.Errorf("access token of the revoked session: revoked = %v, err = %v", revoked, err)
+	}
+}
+
+func TestRevokeAllUserTokens(t *testing.T) {
+	userID := newTestStorage(t)
+
+	refresh, err := IssueRefreshToken(userID, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, _, err := GenerateJWT(userID, nil, refresh.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa, _, err := GenerateMFAToken(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RevokeAllUserTokens(userID); err != nil {
+		t.Fatal(err)
+	}
+
+	// iat округлён до секунды, поэтому токены выпущены в ту же секунду, что и отзыв, или раньше
+	for name, token := range map[string]struct{ value, typ string }{
+		"access": {access, TokenTypeAccess},
+		"mfa":    {mfa, TokenTypeMFAPending},
+	} {
+		claims, err := ParseToken(token.value, token.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked, err := Revocations.IsRevoked(claims); err != nil || !revoked {
+			t.Errorf("%s token: revoked = %v, err = %v, want revoked", name, revoked, err)
+		}
+	}
+	if _, err := RotateRefreshToken(refresh.Token, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	// Новый вход сразу после отзыва работает, даже если пришёлся на ту же секунду
+	fresh, err := IssueRefreshToken(userID, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	freshAccess, _, err := GenerateJWT(userID, nil, fresh.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(freshAccess, TokenTypeAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := Revocations.IsRevoked(claims); err != nil || revoked {
+		t.Errorf("token issued after revocation: revoked = %v, err = %v", revoked, err)
+	}
+}
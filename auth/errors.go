@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Машиночитаемые коды ошибок проверки токена, которые получает клиент
+const (
+	CodeTokenMissing              = "token_missing"
+	CodeTokenMalformed            = "token_malformed"
+	CodeTokenUnsupportedAlgorithm = "token_unsupported_algorithm"
+	CodeTokenUnknownKey           = "token_unknown_key"
+	CodeTokenInvalidSignature     = "token_invalid_signature"
+	CodeTokenExpired              = "token_expired"
+	CodeTokenNotYetValid          = "token_not_yet_valid"
+	CodeTokenInvalidIssuer        = "token_invalid_issuer"
+	CodeTokenInvalidAudience      = "token_invalid_audience"
+	CodeTokenInvalidType          = "token_invalid_type"
+	CodeTokenRevoked              = "token_revoked"
+	CodeAPIKeyInvalid             = "api_key_invalid"
+)
+
+// TokenError — причина отклонения токена
+type TokenError struct {
+	Code    string
+	Message string
+}
+
+func (e *TokenError) Error() string {
+	return e.Message
+}
+
+func newTokenError(code, message string) *TokenError {
+	return &TokenError{Code: code, Message: message}
+}
+
+// Ошибки функции выбора ключа, по которым определяется код ответа
+var (
+	errUnknownKey        = errors.New("unknown key id")
+	errAlgorithmMismatch = errors.New("signing method does not match key")
+)
+
+// classifyParseError переводит ошибку разбора jwt в TokenError с подходящим кодом
+func classifyParseError(token *jwt.Token, err error) *TokenError {
+	// Алгоритм вне списка разрешённых отклоняется ещё до выбора ключа
+	if token != nil && token.Method != nil && !isAllowedAlgorithm(token.Method.Alg()) {
+		return newTokenError(CodeTokenUnsupportedAlgorithm, "Token signing algorithm is not allowed")
+	}
+	if errors.Is(err, errUnknownKey) {
+		return newTokenError(CodeTokenUnknownKey, "Token is signed with an unknown key")
+	}
+	if errors.Is(err, errAlgorithmMismatch) {
+		return newTokenError(CodeTokenUnsupportedAlgorithm, "Token signing algorithm does not match its key")
+	}
+
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+		return newTokenError(CodeTokenInvalidSignature, "Token signature is invalid")
+	}
+	return newTokenError(CodeTokenMalformed, "Token is malformed")
+}
+
+func isAllowedAlgorithm(alg string) bool {
+	for _, allowed := range allowedAlgorithms {
+		if alg == allowed {
+			return true
+		}
+	}
+	return false
+}
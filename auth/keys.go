@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Минимальный допустимый размер RSA-ключа
+const minRSAKeyBits = 2048
+
+// Key — ключ подписи токенов. У ключей, выведенных из ротации, есть только публичная часть
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// KeySet — набор активных ключей, выбираемых по kid, и ключ, которым подписываются новые токены
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// Текущий набор ключей. Заменяется целиком при перезагрузке, поэтому читается атомарно
+var currentKeys atomic.Pointer[KeySet]
+
+// LoadKeys загружает ключи из PEM-файлов каталога dir. Имя файла без расширения .pem служит kid.
+// Файл с закрытым ключом (RSA или Ed25519) можно использовать для подписи, файл с публичным ключом — только для проверки.
+// Если signingKID пуст, для подписи выбирается закрытый ключ с наибольшим kid
+func LoadKeys(dir, signingKID string) error {
+	if dir == "" {
+		return errors.New("JWT keys directory is not set")
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	set := &KeySet{keys: make(map[string]*Key)}
+	var privateIDs []string
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := loadKey(path, kid)
+		if err != nil {
+			return fmt.Errorf("load key %s: %w", path, err)
+		}
+		set.keys[kid] = key
+		if key.Private != nil {
+			privateIDs = append(privateIDs, kid)
+		}
+	}
+	if len(privateIDs) == 0 {
+		return fmt.Errorf("no private signing keys found in %s", dir)
+	}
+
+	if signingKID == "" {
+		sort.Strings(privateIDs)
+		signingKID = privateIDs[len(privateIDs)-1]
+	}
+	signing, ok := set.keys[signingKID]
+	if !ok || signing.Private == nil {
+		return fmt.Errorf("signing key %q not found or has no private part", signingKID)
+	}
+	set.signing = signing
+
+	currentKeys.Store(set)
+	return nil
+}
+
+// GenerateEphemeralKey создаёт временный ключ Ed25519 и подписывает им новые токены.
+// Ключ живёт только в памяти процесса, поэтому подходит лишь для локальной разработки
+func GenerateEphemeralKey() error {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	key := &Key{ID: "ephemeral", Method: jwt.SigningMethodEdDSA, Private: private, Public: public}
+	currentKeys.Store(&KeySet{signing: key, keys: map[string]*Key{key.ID: key}})
+	return nil
+}
+
+// loadKey разбирает PEM-файл с закрытым или публичным ключом
+func loadKey(path, kid string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only RSA and Ed25519 are allowed", parsed)
+	}
+
+	if rsaKey, ok := key.Public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA key is %d bits, at least %d required", rsaKey.N.BitLen(), minRSAKeyBits)
+	}
+	return key, nil
+}
+
+// signingKey возвращает ключ для подписи новых токенов
+func signingKey() (*Key, error) {
+	set := currentKeys.Load()
+	if set == nil {
+		return nil, errors.New("JWT keys are not loaded")
+	}
+	return set.signing, nil
+}
+
+// verificationKey возвращает ключ проверки по kid
+func verificationKey(kid string) (*Key, bool) {
+	set := currentKeys.Load()
+	if set == nil {
+		return nil, false
+	}
+	key, ok := set.keys[kid]
+	return key, ok
+}
+
+// JWK — публичный ключ в формате JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet — тело ответа /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS возвращает публичные части всех активных ключей, чтобы другие сервисы могли проверять токены
+func PublicJWKS() JWKSet {
+	result := JWKSet{Keys: []JWK{}}
+	set := currentKeys.Load()
+	if set == nil {
+		return result
+	}
+
+	for _, key := range set.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		result.Keys = append(result.Keys, jwk)
+	}
+	sort.Slice(result.Keys, func(i, j int) bool { return result.Keys[i].Kid < result.Keys[j].Kid })
+	return result
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attempts — состояние счётчика неудачных попыток для одного ключа (аккаунта или IP)
+type Attempts struct {
+	Failures    int
+	LockedUntil time.Time
+}
+
+// AttemptStore хранит счётчики неудачных попыток. Реализация в памяти подходит для одного узла;
+// для нескольких узлов нужна общая (например, Redis) реализация того же интерфейса
+type AttemptStore interface {
+	// Get возвращает текущее состояние счётчика
+	Get(key string) (Attempts, error)
+	// Update атомарно изменяет счётчик. Запись хранится не меньше ttl
+	Update(key string, ttl time.Duration, fn func(Attempts) Attempts) (Attempts, error)
+	// Reset удаляет счётчик
+	Reset(key string) error
+}
+
+// MemoryAttemptStore — хранилище счётчиков в памяти процесса
+type MemoryAttemptStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryAttempt
+	lastSweep time.Time
+}
+
+type memoryAttempt struct {
+	attempts  Attempts
+	expiresAt time.Time
+}
+
+// Как часто удаляются устаревшие записи
+const attemptSweepInterval = time.Minute
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{entries: make(map[string]memoryAttempt)}
+}
+
+func (s *MemoryAttemptStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key, time.Now()), nil
+}
+
+func (s *MemoryAttemptStore) Update(key string, ttl time.Duration, fn func(Attempts) Attempts) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+	attempts := fn(s.get(key, now))
+	s.entries[key] = memoryAttempt{attempts: attempts, expiresAt: now.Add(ttl)}
+	return attempts, nil
+}
+
+func (s *MemoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryAttemptStore) get(key string, now time.Time) Attempts {
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		return Attempts{}
+	}
+	return entry.attempts
+}
+
+// sweep удаляет устаревшие записи, чтобы карта не росла бесконечно. Вызывается под мьютексом
+func (s *MemoryAttemptStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < attemptSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// LockoutPolicy задаёт порог и экспоненциальный рост блокировки
+type LockoutPolicy struct {
+	// FreeAttempts — сколько неудач подряд допускается без блокировки
+	FreeAttempts int
+	// BaseDelay — длительность первой блокировки, каждая следующая неудача удваивает её
+	BaseDelay time.Duration
+	// MaxDelay — максимальная длительность блокировки
+	MaxDelay time.Duration
+	// Window — через сколько времени без новых неудач счётчик сбрасывается
+	Window time.Duration
+}
+
+// lockDuration возвращает длительность блокировки после указанного числа неудач
+func (p LockoutPolicy) lockDuration(failures int) time.Duration {
+	over := failures - p.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < over && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Политики по умолчанию: аккаунт защищается строже, IP — мягче, так как за ним может быть много пользователей
+var (
+	DefaultAccountPolicy = LockoutPolicy{FreeAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour}
+	DefaultIPPolicy      = LockoutPolicy{FreeAttempts: 20, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+)
+
+// Lockout ограничивает число неудачных проверок учётных данных по аккаунту и по IP
+type Lockout struct {
+	store   AttemptStore
+	account LockoutPolicy
+	ip      LockoutPolicy
+}
+
+func NewLockout(store AttemptStore, account, ip LockoutPolicy) *Lockout {
+	return &Lockout{store: store, account: account, ip: ip}
+}
+
+// LoginLockout — ограничитель для входа, второго фактора и смены пароля
+var LoginLockout = NewLockout(NewMemoryAttemptStore(), DefaultAccountPolicy, DefaultIPPolicy)
+
+// Политики для запросов сброса пароля: каждый запрос считается попыткой, поэтому письма на один адрес
+// уходят не чаще раза в несколько минут, а с одного IP можно запросить не больше десятка писем подряд
+var (
+	PasswordResetAccountPolicy = LockoutPolicy{FreeAttempts: 0, BaseDelay: 2 * time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	PasswordResetIPPolicy      = LockoutPolicy{FreeAttempts: 10, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+)
+
+// PasswordResetLockout ограничивает отправку писем для сброса пароля по адресу и по IP
+var PasswordResetLockout = NewLockout(NewMemoryAttemptStore(), PasswordResetAccountPolicy, PasswordResetIPPolicy)
+
+// Check возвращает, сколько ещё осталось ждать, если аккаунт или IP заблокированы, иначе 0
+func (l *Lockout) Check(email, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		attempts, err := l.store.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		if remaining := attempts.LockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// RecordFailure учитывает неудачную попытку для аккаунта и IP
+func (l *Lockout) RecordFailure(email, ip string) error {
+	if err := l.recordFailure(accountKey(email), l.account); err != nil {
+		return err
+	}
+	return l.recordFailure(ipKey(ip), l.ip)
+}
+
+// RecordSuccess сбрасывает счётчик аккаунта после успешной проверки. Счётчик IP не сбрасывается,
+// иначе перебор по многим аккаунтам можно было бы «разбавлять» входами в свой
+func (l *Lockout) RecordSuccess(email string) error {
+	return l.store.Reset(accountKey(email))
+}
+
+// Unlock снимает блокировку аккаунта (используется администратором)
+func (l *Lockout) Unlock(email string) error {
+	return l.store.Reset(accountKey(email))
+}
+
+func (l *Lockout) recordFailure(key string, policy LockoutPolicy) error {
+	ttl := policy.Window + policy.MaxDelay
+	_, err := l.store.Update(key, ttl, func(attempts Attempts) Attempts {
+		attempts.Failures++
+		if delay := policy.lockDuration(attempts.Failures); delay > 0 {
+			attempts.LockedUntil = time.Now().Add(delay)
+		}
+		return attempts
+	})
+	return err
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutBackoff(t *testing.T) {
+	account := LockoutPolicy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: 4 * time.Minute, Window: time.Hour}
+	ip := LockoutPolicy{FreeAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	lockout := NewLockout(NewMemoryAttemptStore(), account, ip)
+
+	// Первые неудачи бесплатны, дальше блокировка удваивается до MaxDelay
+	for i, want := range []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		if err := lockout.RecordFailure("Alice@Example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		wait, err := lockout.Check("alice@example.com", "10.0.0.2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait > want || wait < want-time.Second {
+			t.Errorf("after %d failures: wait = %v, want %v", i+1, wait, want)
+		}
+	}
+
+	// Снятие блокировки администратором
+	if err := lockout.Unlock("alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, err := lockout.Check("alice@example.com", "10.0.0.1"); err != nil || wait != 0 {
+		t.Errorf("after unlock: wait = %v, err = %v", wait, err)
+	}
+}
+
+func TestLockoutByIP(t *testing.T) {
+	account := LockoutPolicy{FreeAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	ip := LockoutPolicy{FreeAttempts: 1, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	lockout := NewLockout(NewMemoryAttemptStore(), account, ip)
+
+	// Перебор по разным аккаунтам с одного IP блокирует IP, а успешный вход в свой аккаунт его не сбрасывает
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		if err := lockout.RecordFailure(email, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lockout.RecordSuccess("c@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := lockout.Check("c@example.com", "10.0.0.1"); wait == 0 {
+		t.Error("IP is not locked")
+	}
+	if wait, _ := lockout.Check("c@example.com", "10.0.0.2"); wait != 0 {
+		t.Errorf("another IP locked for %v", wait)
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+	"time"
+)
+
+// Назначения одноразовых токенов, которые отправляются пользователю по почте
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+)
+
+// ErrInvalidOneTimeToken возвращается для неизвестного, истёкшего или уже использованного токена
+var ErrInvalidOneTimeToken = errors.New("invalid or expired token")
+
+// IssueOneTimeToken выпускает одноразовый токен с ограниченным сроком действия.
+// Ранее выданные неиспользованные токены того же назначения перестают действовать
+func IssueOneTimeToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = storage.Transaction(func(tx repository.Repositories) error {
+		now := time.Now()
+		if err := tx.OneTimeTokens.InvalidateUnused(userID, purpose, now); err != nil {
+			return err
+		}
+		record := models.OneTimeToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hash,
+			ExpiresAt: now.Add(ttl),
+		}
+		return tx.OneTimeTokens.Create(&record)
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeOneTimeToken погашает токен вместе с остальными неиспользованными токенами того же назначения
+// и возвращает пользователя, которому он был выдан. Дополнительные изменения (например, подтверждение email)
+// выполняются в той же транзакции через apply
+func ConsumeOneTimeToken(token, purpose string, apply func(tx repository.Repositories, userID int) error) (int, error) {
+	var userID int
+	err := storage.Transaction(func(tx repository.Repositories) error {
+		stored, err := tx.OneTimeTokens.LockByHash(HashOpaqueToken(token), purpose)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return ErrInvalidOneTimeToken
+			}
+			return err
+		}
+
+		now := time.Now()
+		if stored.UsedAt != nil || now.After(stored.ExpiresAt) {
+			return ErrInvalidOneTimeToken
+		}
+		if err := tx.OneTimeTokens.InvalidateUnused(stored.UserID, purpose, now); err != nil {
+			return err
+		}
+
+		userID = stored.UserID
+		if apply != nil {
+			return apply(tx, stored.UserID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Алгоритмы хеширования паролей. Алгоритм и его параметры записываются в сам хеш
+// (префикс $2a$/$2b$ у bcrypt и $argon2id$ в формате PHC), поэтому хеши разных алгоритмов могут храниться вместе
+const (
+	PasswordAlgorithmBcrypt   = "bcrypt"
+	PasswordAlgorithmArgon2id = "argon2id"
+)
+
+// Параметры хеширования по умолчанию
+const (
+	defaultBcryptCost    = 12
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024 // КиБ
+	defaultArgon2Threads = 2
+	argon2SaltLength     = 16
+	argon2KeyLength      = 32
+)
+
+// ErrUnknownPasswordHash возвращается для хеша, формат которого не распознан
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher хеширует и проверяет пароли текущим алгоритмом с текущими параметрами
+type PasswordHasher struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+// Passwords — хешер паролей, который используют обработчики
+var Passwords = PasswordHasher{
+	Algorithm:     PasswordAlgorithmBcrypt,
+	BcryptCost:    defaultBcryptCost,
+	Argon2Time:    defaultArgon2Time,
+	Argon2Memory:  defaultArgon2Memory,
+	Argon2Threads: defaultArgon2Threads,
+}
+
+// PasswordHasherFromEnv настраивает хешер по переменным окружения PASSWORD_HASH (bcrypt или argon2id),
+// BCRYPT_COST, ARGON2_TIME, ARGON2_MEMORY (в КиБ) и ARGON2_THREADS
+func PasswordHasherFromEnv() (PasswordHasher, error) {
+	hasher := Passwords
+	hasher.Algorithm = stringFromEnv("PASSWORD_HASH", PasswordAlgorithmBcrypt)
+	if hasher.Algorithm != PasswordAlgorithmBcrypt && hasher.Algorithm != PasswordAlgorithmArgon2id {
+		return hasher, fmt.Errorf("unknown PASSWORD_HASH %q", hasher.Algorithm)
+	}
+
+	cost, err := intFromEnv("BCRYPT_COST", defaultBcryptCost)
+	if err != nil {
+		return hasher, err
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return hasher, fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	hasher.BcryptCost = cost
+
+	timeCost, err := intFromEnv("ARGON2_TIME", defaultArgon2Time)
+	if err != nil {
+		return hasher, err
+	}
+	memory, err := intFromEnv("ARGON2_MEMORY", defaultArgon2Memory)
+	if err != nil {
+		return hasher, err
+	}
+	threads, err := intFromEnv("ARGON2_THREADS", defaultArgon2Threads)
+	if err != nil {
+		return hasher, err
+	}
+	if timeCost < 1 || memory < 8*threads || threads < 1 || threads > 255 {
+		return hasher, errors.New("invalid argon2id parameters")
+	}
+	hasher.Argon2Time = uint32(timeCost)
+	hasher.Argon2Memory = uint32(memory)
+	hasher.Argon2Threads = uint8(threads)
+	return hasher, nil
+}
+
+// Hash хеширует пароль текущим алгоритмом
+func (h PasswordHasher) Hash(password string) (string, error) {
+	if h.Algorithm == PasswordAlgorithmArgon2id {
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.Argon2Time, h.Argon2Memory, h.Argon2Threads, argon2KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+			h.Argon2Memory, h.Argon2Time, h.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+	return string(hash), err
+}
+
+// Verify сверяет пароль с хешем любого поддерживаемого алгоритма. needsRehash сообщает,
+// что хеш создан другим алгоритмом или с устаревшими параметрами и его стоит пересчитать
+func (h PasswordHasher) Verify(hash, password string) (ok bool, needsRehash bool, err error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := parseArgon2Hash(hash)
+		if err != nil {
+			return false, false, err
+		}
+		computed := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+		outdated := h.Algorithm != PasswordAlgorithmArgon2id ||
+			params.time != h.Argon2Time || params.memory != h.Argon2Memory || params.threads != h.Argon2Threads ||
+			len(key) != argon2KeyLength
+		return true, outdated, nil
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, ErrUnknownPasswordHash
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	return true, h.Algorithm != PasswordAlgorithmBcrypt || cost != h.BcryptCost, nil
+}
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// parseArgon2Hash разбирает хеш вида $argon2id$v=19$m=65536,t=3,p=2$<соль>$<ключ>
+func parseArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	return params, salt, key, nil
+}
+
+// intFromEnv читает целое число из переменной окружения, при отсутствии возвращает значение по умолчанию
+func intFromEnv(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", name)
+	}
+	return number, nil
+}
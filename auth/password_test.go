@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	bcryptHasher := PasswordHasher{Algorithm: PasswordAlgorithmBcrypt, BcryptCost: bcrypt.MinCost}
+	strongerBcrypt := bcryptHasher
+	strongerBcrypt.BcryptCost++
+	argonHasher := PasswordHasher{Algorithm: PasswordAlgorithmArgon2id, Argon2Time: 1, Argon2Memory: 64, Argon2Threads: 1}
+	strongerArgon := argonHasher
+	strongerArgon.Argon2Time++
+
+	bcryptHash, err := bcryptHasher.Hash("Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argonHash, err := argonHasher.Hash("Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		hasher      PasswordHasher
+		hash        string
+		needsRehash bool
+	}{
+		{"bcrypt with current cost", bcryptHasher, bcryptHash, false},
+		{"bcrypt with outdated cost", strongerBcrypt, bcryptHash, true},
+		{"bcrypt when argon2id is current", argonHasher, bcryptHash, true},
+		{"argon2id with current parameters", argonHasher, argonHash, false},
+		{"argon2id with outdated parameters", strongerArgon, argonHash, true},
+		{"argon2id when bcrypt is current", bcryptHasher, argonHash, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := tt.hasher.Verify(tt.hash, "Secret123!")
+			if err != nil || !ok {
+				t.Fatalf("ok = %v, err = %v", ok, err)
+			}
+			if needsRehash != tt.needsRehash {
+				t.Errorf("needsRehash = %v, want %v", needsRehash, tt.needsRehash)
+			}
+			// Неверный пароль не совпадает и не требует пересчёта
+			if ok, needsRehash, err := tt.hasher.Verify(tt.hash, "Wrong123!"); ok || needsRehash || err != nil {
+				t.Errorf("wrong password: ok = %v, needsRehash = %v, err = %v", ok, needsRehash, err)
+			}
+		})
+	}
+
+	if _, _, err := bcryptHasher.Verify("plain-text", "plain-text"); !errors.Is(err, ErrUnknownPasswordHash) {
+		t.Errorf("unknown hash: err = %v, want ErrUnknownPasswordHash", err)
+	}
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidRefreshToken возвращается для неизвестного, истёкшего или отозванного refresh-токена
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused возвращается при повторном предъявлении уже использованного токена.
+	// К этому моменту всё семейство токенов уже отозвано
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// IssuedRefreshToken — выпущенный refresh-токен и сессия, к которой он относится
+type IssuedRefreshToken struct {
+	Token     string
+	ExpiresAt time.Time
+	UserID    int
+	SessionID string
+}
+
+// IssueRefreshToken создаёт новую сессию и первое семейство refresh-токенов для пользователя (например, при входе).
+// Идентификатор сессии совпадает с идентификатором семейства
+func IssueRefreshToken(userID int, client ClientInfo) (*IssuedRefreshToken, error) {
+	sessionID, _, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var issued *IssuedRefreshToken
+	err = storage.Transaction(func(tx repository.Repositories) error {
+		if err := createSession(tx, sessionID, userID, client); err != nil {
+			return err
+		}
+		issued, err = createRefreshToken(tx, userID, sessionID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+// RotateRefreshToken погашает предъявленный refresh-токен и выпускает вместо него новый в том же семействе.
+// Повторное использование уже погашенного токена отзывает всё семейство
+func RotateRefreshToken(token string, client ClientInfo) (*IssuedRefreshToken, error) {
+	reused := false
+	var issued *IssuedRefreshToken
+	err := storage.Transaction(func(tx repository.Repositories) error {
+		// Блокируем строку токена, чтобы два параллельных обновления не погасили его дважды
+		stored, err := tx.RefreshTokens.LockByHash(HashOpaqueToken(token))
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		now := time.Now()
+		if stored.RevokedAt != nil {
+			return ErrInvalidRefreshToken
+		}
+		if stored.UsedAt != nil {
+			// Токен уже был обменян: вероятна кража, отзываем всё семейство
+			reused = true
+			return revokeRefreshFamily(tx, stored.FamilyID, now)
+		}
+		if now.After(stored.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		// Погашаем текущий токен и выпускаем следующий в том же семействе
+		if err := tx.RefreshTokens.MarkUsed(stored.ID, now); err != nil {
+			return err
+		}
+		if err := touchSession(tx, stored.FamilyID, client, now); err != nil {
+			return err
+		}
+		issued, err = createRefreshToken(tx, stored.UserID, stored.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+	return issued, nil
+}
+
+// createRefreshToken сохраняет хеш нового токена в указанном семействе
+func createRefreshToken(tx repository.Repositories, userID int, familyID string) (*IssuedRefreshToken, error) {
+	token, hash, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(RefreshTokenTTL())
+	record := models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: expiresAt,
+	}
+	if err := tx.RefreshTokens.Create(&record); err != nil {
+		return nil, err
+	}
+	return &IssuedRefreshToken{Token: token, ExpiresAt: expiresAt, UserID: userID, SessionID: familyID}, nil
+}
+
+// revokeRefreshFamily отзывает все ещё не отозванные токены семейства и соответствующую сессию
+func revokeRefreshFamily(tx repository.Repositories, familyID string, now time.Time) error {
+	if err := tx.RefreshTokens.RevokeFamily(familyID, now); err != nil {
+		return err
+	}
+	return tx.Sessions.Revoke(familyID, now)
+}
+
+// RevokeRefreshToken отзывает семейство, которому принадлежит refresh-токен пользователя (выход с одного устройства)
+func RevokeRefreshToken(userID int, token string) error {
+	stored, err := storage.RefreshTokens.GetByHash(HashOpaqueToken(token), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		return err
+	}
+	return storage.Transaction(func(tx repository.Repositories) error {
+		return revokeRefreshFamily(tx, stored.FamilyID, time.Now())
+	})
+}
+
+// revokeUserRefreshTokens отзывает все refresh-токены и сессии пользователя
+func revokeUserRefreshTokens(tx repository.Repositories, userID int, now time.Time) error {
+	if err := tx.RefreshTokens.RevokeUser(userID, now); err != nil {
+		return err
+	}
+	return tx.Sessions.RevokeUser(userID, now)
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"apiForSN/models"
+	"apiForSN/repository"
+	"time"
+)
+
+// RevocationStore хранит сведения об отозванных access-токенах. Middleware проверяет его на каждом запросе
+type RevocationStore interface {
+	// RevokeToken отзывает один токен по его jti до момента истечения токена
+	RevokeToken(jti string, userID int, expiresAt time.Time) error
+	// RevokeUserTokens отзывает токены пользователя без сессии, выпущенные не позже before
+	RevokeUserTokens(userID int, before time.Time) error
+	// IsRevoked сообщает, отозван ли токен с указанными claims
+	IsRevoked(claims *Claims) (bool, error)
+}
+
+// Revocations — хранилище отзывов, которое используют middleware и обработчики
+var Revocations RevocationStore = StorageRevocationStore{}
+
+// StorageRevocationStore хранит отзывы в хранилище пакета (см. UseStorage)
+type StorageRevocationStore struct{}
+
+func (StorageRevocationStore) RevokeToken(jti string, userID int, expiresAt time.Time) error {
+	return storage.Revocations.RevokeToken(models.RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt})
+}
+
+func (StorageRevocationStore) RevokeUserTokens(userID int, before time.Time) error {
+	return storage.Revocations.RevokeUserTokens(userID, before)
+}
+
+func (StorageRevocationStore) IsRevoked(claims *Claims) (bool, error) {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	return storage.Revocations.IsRevoked(claims.ID, claims.UserID, issuedAt, claims.SessionID)
+}
+
+// RevokeAllUserTokens отзывает все access- и refresh-токены пользователя: «выход везде»,
+// смена пароля, удаление аккаунта
+func RevokeAllUserTokens(userID int) error {
+	// Токены с сессией отзываются вместе с сессиями, поэтому новые токены, выпущенные сразу после отзыва
+	// (например, при смене пароля), остаются действительными. Токены без сессии, как промежуточный
+	// MFA-токен, отзываются по границе: iat округлён до секунды, и граница покрывает всю секунду отзыва
+	now := time.Now()
+	if err := Revocations.RevokeUserTokens(userID, now); err != nil {
+		return err
+	}
+	return storage.Transaction(func(tx repository.Repositories) error {
+		return revokeUserRefreshTokens(tx, userID, now)
+	})
+}
@@ -0,0 +1,72 @@
+package auth
+
+import "sort"
+
+// Роли пользователей
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Области доступа (scopes). Для пользователя они выводятся из ролей
+const (
+	ScopeRead         = "read"
+	ScopePostWrite    = "post:write"
+	ScopeCommentWrite = "comment:write"
+	ScopeAccount      = "account"
+	ScopeModerate     = "moderate"
+	ScopeAdmin        = "admin"
+)
+
+// Области доступа, которые даёт каждая роль
+var roleScopes = map[string][]string{
+	RoleUser:      {ScopeRead, ScopePostWrite, ScopeCommentWrite, ScopeAccount},
+	RoleModerator: {ScopeRead, ScopePostWrite, ScopeCommentWrite, ScopeAccount, ScopeModerate},
+	RoleAdmin:     {ScopeRead, ScopePostWrite, ScopeCommentWrite, ScopeAccount, ScopeModerate, ScopeAdmin},
+}
+
+// ValidRole сообщает, известна ли роль
+func ValidRole(role string) bool {
+	_, ok := roleScopes[role]
+	return ok
+}
+
+// ScopesForRoles объединяет области доступа всех ролей. Без ролей пользователь считается обычным
+func ScopesForRoles(roles []string) []string {
+	if len(roles) == 0 {
+		roles = []string{RoleUser}
+	}
+
+	set := make(map[string]bool)
+	for _, role := range roles {
+		for _, scope := range roleScopes[role] {
+			set[scope] = true
+		}
+	}
+	scopes := make([]string, 0, len(set))
+	for scope := range set {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// HasRole сообщает, входит ли роль в список
+func HasRole(roles []string, role string) bool {
+	return contains(roles, role)
+}
+
+// HasScope сообщает, входит ли scope в список
+func HasScope(scopes []string, scope string) bool {
+	return contains(scopes, scope)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ограничения на длину сохраняемых сведений о клиенте
+const (
+	maxUserAgentLength = 512
+	maxIPLength        = 64
+)
+
+// ErrSessionNotFound возвращается, если у пользователя нет такой активной сессии
+var ErrSessionNotFound = errors.New("session not found")
+
+// ClientInfo — сведения об устройстве, с которого выполнен вход
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+func (c ClientInfo) truncated() ClientInfo {
+	if len(c.UserAgent) > maxUserAgentLength {
+		c.UserAgent = c.UserAgent[:maxUserAgentLength]
+	}
+	if len(c.IP) > maxIPLength {
+		c.IP = c.IP[:maxIPLength]
+	}
+	return c
+}
+
+// createSession сохраняет новую сессию в рамках транзакции tx
+func createSession(tx repository.Repositories, sessionID string, userID int, client ClientInfo) error {
+	client = client.truncated()
+	now := time.Now()
+	return tx.Sessions.Create(&models.Session{
+		ID:         sessionID,
+		UserID:     userID,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	})
+}
+
+// touchSession обновляет сведения о клиенте при обмене refresh-токена
+func touchSession(tx repository.Repositories, sessionID string, client ClientInfo, now time.Time) error {
+	client = client.truncated()
+	return tx.Sessions.Touch(sessionID, client.UserAgent, client.IP, now)
+}
+
+// ListSessions возвращает активные сессии пользователя, начиная с последней использованной
+func ListSessions(userID int) ([]models.Session, error) {
+	sessions, err := storage.Sessions.ListActive(userID, time.Now().Add(-RefreshTokenTTL()))
+	if err != nil {
+		return nil, err
+	}
+
+	// Учитываем обращения, которые ещё не записаны в базу. Они могут изменить порядок, полученный из хранилища
+	for i := range sessions {
+		if seen, ok := Sessions.lastSeen(sessions[i].ID); ok && seen.After(sessions[i].LastSeenAt) {
+			sessions[i].LastSeenAt = seen
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+// RevokeSession завершает сессию пользователя: её refresh-токены и access-токены перестают действовать
+func RevokeSession(userID int, sessionID string) error {
+	return storage.Transaction(func(tx repository.Repositories) error {
+		session, err := tx.Sessions.GetActive(sessionID, userID)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return ErrSessionNotFound
+			}
+			return err
+		}
+		return revokeRefreshFamily(tx, session.ID, time.Now())
+	})
+}
+
+// SessionTracker накапливает время последнего обращения сессий в памяти и периодически записывает его
+// в базу одним пакетом, чтобы middleware не выполнял запись на каждый запрос
+type SessionTracker struct {
+	mu      sync.Mutex
+	pending map[string]time.Time
+}
+
+// Sessions — трекер активности сессий, который использует middleware
+var Sessions = &SessionTracker{pending: make(map[string]time.Time)}
+
+// Touch отмечает обращение к сессии
+func (t *SessionTracker) Touch(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	t.mu.Lock()
+	t.pending[sessionID] = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *SessionTracker) lastSeen(sessionID string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen, ok := t.pending[sessionID]
+	return seen, ok
+}
+
+// Flush записывает накопленные отметки в базу
+func (t *SessionTracker) Flush() error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[string]time.Time)
+	t.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	err := storage.Transaction(func(tx repository.Repositories) error {
+		for sessionID, seen := range pending {
+			if err := tx.Sessions.UpdateLastSeen(sessionID, seen); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Возвращаем отметки обратно, чтобы не потерять их до следующей попытки
+		t.mu.Lock()
+		for sessionID, seen := range pending {
+			if current, ok := t.pending[sessionID]; !ok || seen.After(current) {
+				t.pending[sessionID] = seen
+			}
+		}
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// Start периодически сбрасывает отметки в базу с указанным интервалом
+func (t *SessionTracker) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := t.Flush(); err != nil {
+				log.Printf("Failed to flush session activity: %v", err)
+			}
+		}
+	}()
+}
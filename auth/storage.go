@@ -0,0 +1,11 @@
+package auth
+
+import "apiForSN/repository"
+
+// storage — хранилище токенов, сессий и ключей API, с которым работает пакет
+var storage repository.Repositories
+
+// UseStorage задаёт хранилище пакета. Вызывается при запуске до обработки запросов
+func UseStorage(repos repository.Repositories) {
+	storage = repos
+}
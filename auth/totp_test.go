@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// Тестовые значения RFC 6238 (приложение B) для SHA-1. В RFC коды восьмизначные,
+// а здесь шестизначные, поэтому ожидаются последние шесть цифр
+func TestVerifyTOTPRFC6238(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		step, ok := VerifyTOTP(secret, tt.code, now)
+		if !ok {
+			t.Errorf("T=%d: code %s rejected", tt.unix, tt.code)
+			continue
+		}
+		if want := tt.unix / 30; step != want {
+			t.Errorf("T=%d: step = %d, want %d", tt.unix, step, want)
+		}
+	}
+}
+
+func TestVerifyTOTPSkew(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	// Код для T=59 (интервал 1) принимается в соседнем интервале и отклоняется через один
+	if _, ok := VerifyTOTP(secret, "287082", time.Unix(89, 0)); !ok {
+		t.Error("code from the previous step rejected")
+	}
+	if _, ok := VerifyTOTP(secret, "287082", time.Unix(119, 0)); ok {
+		t.Error("code two steps old accepted")
+	}
+	if _, ok := VerifyTOTP(secret, "28708", time.Unix(59, 0)); ok {
+		t.Error("short code accepted")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, input := range []string{"abcde-fghij", " ABCDE-FGHIJ ", "abcdefghij", "abcde fghij"} {
+		if got := NormalizeRecoveryCode(input); got != "abcde-fghij" {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q", input, got)
+		}
+	}
+}
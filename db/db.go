@@ -3,7 +3,11 @@ package db
 import (
 	"fmt"
 	"log"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/glebarez/sqlite"
 	_ "github.com/lib/pq"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -13,10 +17,21 @@ var (
 	DB *gorm.DB
 )
 
-// Подключаемся к базе данных
+// Диалекты базы данных. Совпадают с именами диалектов GORM
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// Подключаемся к базе данных. Диалект определяется по строке подключения:
+// sqlite:<путь>, file:<путь> или путь к файлу *.db, *.sqlite, *.sqlite3 — SQLite, всё остальное — Postgres
 func Connect(connStr string) {
 	var err error
-	DB, err = gorm.Open(postgres.Open(connStr), &gorm.Config{})
+	if path, ok := sqlitePath(connStr); ok {
+		err = connectSQLite(path)
+	} else {
+		DB, err = gorm.Open(postgres.Open(connStr), gormConfig())
+	}
 	if err != nil {
 		log.Fatal("Ошибка подключения к базе данных:", err)
 	}
@@ -36,40 +51,64 @@ func Connect(connStr string) {
 
 }
 
-// InitTables инициализирует таблицы
-func InitTables() {
-	// Создание таблицы пользователей
-	query := `
-    CREATE TABLE IF NOT EXISTS users (
-        id SERIAL PRIMARY KEY,
-        nickname VARCHAR(50) NOT NULL,
-		email VARCHAR(100) NOT NULL,
-        password VARCHAR(50) NOT NULL
-    );
-    CREATE TABLE IF NOT EXISTS posts (
-        id SERIAL PRIMARY KEY,
-        user_id INTEGER REFERENCES users(id),
-		date DATE NOT NULL,
-        content TEXT NOT NULL,
-		likes INTEGER NOT NULL,
-		comments INTEGER NOT NULL
-    );
-    CREATE TABLE IF NOT EXISTS comments (
-        id SERIAL PRIMARY KEY,
-        user_id INTEGER REFERENCES users(id),
-        post_id INTEGER REFERENCES posts(id),
-		date DATE NOT NULL,
-        content TEXT NOT NULL,
-		likes INTEGER NOT NULL
-    );
-	CREATE TABLE IF NOT EXISTS likes (
-        id SERIAL PRIMARY KEY,
-        user_id INTEGER REFERENCES users(id),
-        post_id INTEGER REFERENCES posts(id),
-		comment_id INTEGER REFERENCES comments(id)
-	);`
-	err := DB.Exec(query).Error
+// gormConfig — настройки GORM для обоих диалектов. Время создания и обновления записей GORM берёт в UTC,
+// как и остальное время, которое пишут репозитории (SQLite сравнивает время строками)
+func gormConfig() *gorm.Config {
+	return &gorm.Config{NowFunc: func() time.Time { return time.Now().UTC() }}
+}
+
+// Dialect возвращает диалект текущего подключения
+func Dialect() string {
+	return DB.Dialector.Name()
+}
+
+// sqlitePath выделяет путь к файлу SQLite из строки подключения
+func sqlitePath(connStr string) (string, bool) {
+	for _, prefix := range []string{"sqlite://", "sqlite:"} {
+		if strings.HasPrefix(connStr, prefix) {
+			return strings.TrimPrefix(connStr, prefix), true
+		}
+	}
+	if strings.HasPrefix(connStr, "file:") {
+		return connStr, true
+	}
+	path, _, _ := strings.Cut(connStr, "?")
+	for _, ext := range []string{".db", ".sqlite", ".sqlite3"} {
+		if strings.HasSuffix(path, ext) {
+			return connStr, true
+		}
+	}
+	return "", false
+}
+
+// connectSQLite открывает файл SQLite с настройками для конкурентной работы сервера
+func connectSQLite(dsn string) error {
+	path, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	// Ждём освобождения блокировки вместо немедленной ошибки SQLITE_BUSY, читатели не мешают писателю (WAL),
+	// внешние ключи проверяются, как в Postgres
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "foreign_keys(1)")
+	// SQLite не поддерживает SELECT ... FOR UPDATE. Транзакция сразу берёт блокировку записи (BEGIN IMMEDIATE),
+	// поэтому чтение с последующим изменением (погашение токенов, счётчики) не пересекается с другими транзакциями
+	if params.Get("_txlock") == "" {
+		params.Set("_txlock", "immediate")
+	}
+
+	DB, err = gorm.Open(sqlite.Open(path+"?"+params.Encode()), gormConfig())
+	if err != nil {
+		return err
+	}
+	sqlDB, err := DB.DB()
 	if err != nil {
-		log.Fatal("Ошибка инициализации таблиц:", err)
+		return err
 	}
+	// Пишет в SQLite всё равно один процесс за раз. Одно соединение исключает взаимные блокировки
+	// между соединениями пула и сохраняет базу :memory: между запросами
+	sqlDB.SetMaxOpenConns(1)
+	return nil
 }
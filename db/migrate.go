@@ -0,0 +1,306 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Файлы миграций встроены в бинарник, у каждого диалекта свой каталог с общей нумерацией версий.
+// Имя файла: <версия>_<название>.up.sql или <версия>_<название>.down.sql
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// Ключ advisory-блокировки, под которой выполняются миграции. Пока один экземпляр мигрирует, остальные ждут
+const migrationLockKey int64 = 7_415_289_001
+
+// Migration — одна версия схемы
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState — миграция и момент её применения (nil, если ещё не применена)
+type MigrationState struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// loadMigrations читает встроенные миграции текущего диалекта, упорядоченные по версии
+func loadMigrations() ([]Migration, error) {
+	dir := path.Join("migrations", Dialect())
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionPart, title, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionPart)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name> file name", name)
+		}
+		content, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: title}
+			byVersion[version] = migration
+		}
+		if migration.Name != title {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, migration.Name, title)
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp применяет все ещё не применённые миграции и возвращает их
+func MigrateUp() ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	err = withMigrationLock(func(conn *sql.Conn) error {
+		done, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err := runMigration(conn, migration, migration.Up, true); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown откатывает последние steps применённых миграций и возвращает их
+func MigrateDown(steps int) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	err = withMigrationLock(func(conn *sql.Conn) error {
+		done, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be reverted: no down file", migration.Version, migration.Name)
+			}
+			if err := runMigration(conn, migration, migration.Down, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus возвращает все известные миграции с отметкой о применении
+func MigrationStatus() ([]MigrationState, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var states []MigrationState
+	err = withMigrationLock(func(conn *sql.Conn) error {
+		done, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			state := MigrationState{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := done[migration.Version]; ok {
+				state.AppliedAt = &appliedAt
+				delete(done, migration.Version)
+			}
+			states = append(states, state)
+		}
+		// Версии, которые есть в базе, но неизвестны этому бинарнику (база новее кода)
+		for version, appliedAt := range done {
+			appliedAt := appliedAt
+			states = append(states, MigrationState{Version: version, Name: "(unknown)", AppliedAt: &appliedAt})
+		}
+		sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+		return nil
+	})
+	return states, err
+}
+
+// withMigrationLock выполняет fn на выделенном соединении под advisory-блокировкой.
+// Блокировка сеансовая, поэтому все запросы должны идти через одно и то же соединение.
+// В SQLite advisory-блокировок нет: там у сервера одно соединение, а каждая миграция выполняется в транзакции
+// с блокировкой записи, так что второй процесс не сможет применить ту же версию повторно
+func withMigrationLock(fn func(conn *sql.Conn) error) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	createTable := `CREATE TABLE IF NOT EXISTS schema_migrations (
+        version BIGINT PRIMARY KEY,
+        name VARCHAR(255) NOT NULL,
+        applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+	if Dialect() == DialectSQLite {
+		createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+        version BIGINT PRIMARY KEY,
+        name VARCHAR(255) NOT NULL,
+        applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+	} else {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	}
+
+	if _, err := conn.ExecContext(ctx, createTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+// appliedVersions возвращает применённые версии и время их применения
+func appliedVersions(conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration выполняет SQL миграции и запись в schema_migrations в одной транзакции,
+// поэтому неудачная миграция не оставляет схему в промежуточном состоянии
+func runMigration(conn *sql.Conn, migration Migration, script string, up bool) error {
+	ctx := context.Background()
+	sqlite := Dialect() == DialectSQLite
+	if sqlite {
+		// Изменить ограничения таблицы в SQLite можно только пересоздав её, а удаление таблицы, на которую
+		// ссылаются другие, нарушает внешние ключи. На время миграции проверку отключаем (внутри транзакции
+		// это сделать нельзя) и проверяем целостность целиком перед фиксацией
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if sqlite {
+		if err := checkForeignKeys(tx); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkForeignKeys проверяет внешние ключи всей базы SQLite и возвращает первое нарушение
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.QueryContext(context.Background(), "PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var index int
+		if err := rows.Scan(&table, &rowID, &parent, &index); err != nil {
+			return err
+		}
+		return fmt.Errorf("foreign key violation: row %d of %s references missing %s", rowID.Int64, table, parent)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,16 @@
+ALTER TABLE users DROP COLUMN updated_at;
+ALTER TABLE users DROP COLUMN created_at;
+
+ALTER TABLE comments ADD COLUMN date DATE;
+UPDATE comments SET date = created_at::DATE;
+ALTER TABLE comments ALTER COLUMN date SET NOT NULL;
+ALTER TABLE comments DROP COLUMN edited_at;
+ALTER TABLE comments DROP COLUMN updated_at;
+ALTER TABLE comments DROP COLUMN created_at;
+
+ALTER TABLE posts ADD COLUMN date DATE;
+UPDATE posts SET date = created_at::DATE;
+ALTER TABLE posts ALTER COLUMN date SET NOT NULL;
+ALTER TABLE posts DROP COLUMN edited_at;
+ALTER TABLE posts DROP COLUMN updated_at;
+ALTER TABLE posts DROP COLUMN created_at;
@@ -0,0 +1,21 @@
+-- Даты постов и комментариев хранились в колонке date типа DATE, а код писал туда Unix-время.
+-- Переходим на TIMESTAMPTZ: created_at — создание, updated_at — любое изменение строки, edited_at — правка текста автором
+ALTER TABLE posts ADD COLUMN created_at TIMESTAMPTZ;
+ALTER TABLE posts ADD COLUMN updated_at TIMESTAMPTZ;
+ALTER TABLE posts ADD COLUMN edited_at TIMESTAMPTZ;
+UPDATE posts SET created_at = date::TIMESTAMPTZ, updated_at = date::TIMESTAMPTZ;
+ALTER TABLE posts ALTER COLUMN created_at SET NOT NULL, ALTER COLUMN created_at SET DEFAULT NOW();
+ALTER TABLE posts ALTER COLUMN updated_at SET NOT NULL, ALTER COLUMN updated_at SET DEFAULT NOW();
+ALTER TABLE posts DROP COLUMN date;
+
+ALTER TABLE comments ADD COLUMN created_at TIMESTAMPTZ;
+ALTER TABLE comments ADD COLUMN updated_at TIMESTAMPTZ;
+ALTER TABLE comments ADD COLUMN edited_at TIMESTAMPTZ;
+UPDATE comments SET created_at = date::TIMESTAMPTZ, updated_at = date::TIMESTAMPTZ;
+ALTER TABLE comments ALTER COLUMN created_at SET NOT NULL, ALTER COLUMN created_at SET DEFAULT NOW();
+ALTER TABLE comments ALTER COLUMN updated_at SET NOT NULL, ALTER COLUMN updated_at SET DEFAULT NOW();
+ALTER TABLE comments DROP COLUMN date;
+
+ALTER TABLE users ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE users ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
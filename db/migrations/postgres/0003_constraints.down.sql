@@ -0,0 +1,21 @@
+ALTER TABLE likes
+    DROP CONSTRAINT likes_user_id_fkey,
+    DROP CONSTRAINT likes_post_id_fkey,
+    DROP CONSTRAINT likes_comment_id_fkey,
+    ADD CONSTRAINT likes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id),
+    ADD CONSTRAINT likes_post_id_fkey FOREIGN KEY (post_id) REFERENCES posts(id),
+    ADD CONSTRAINT likes_comment_id_fkey FOREIGN KEY (comment_id) REFERENCES comments(id);
+ALTER TABLE comments
+    DROP CONSTRAINT comments_user_id_fkey,
+    DROP CONSTRAINT comments_post_id_fkey,
+    ADD CONSTRAINT comments_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id),
+    ADD CONSTRAINT comments_post_id_fkey FOREIGN KEY (post_id) REFERENCES posts(id);
+ALTER TABLE posts
+    DROP CONSTRAINT posts_user_id_fkey,
+    ADD CONSTRAINT posts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+DROP INDEX users_nickname_key;
+DROP INDEX users_email_key;
+DROP INDEX likes_user_comment_key;
+DROP INDEX likes_user_post_key;
+ALTER TABLE likes DROP CONSTRAINT likes_one_target_check;
@@ -0,0 +1,33 @@
+-- Ограничения целостности, которые раньше проверялись только в коде.
+-- Повторные лайки и лайки без цели (или сразу с двумя целями) удаляем: счётчики после этого исправит сверка.
+-- Совпадающие email и никнеймы автоматически не исправить: если они есть, миграция остановится на создании индекса
+DELETE FROM likes a USING likes b
+WHERE a.id > b.id
+  AND a.user_id = b.user_id
+  AND a.post_id IS NOT DISTINCT FROM b.post_id
+  AND a.comment_id IS NOT DISTINCT FROM b.comment_id;
+DELETE FROM likes WHERE (post_id IS NULL) = (comment_id IS NULL);
+
+ALTER TABLE likes ADD CONSTRAINT likes_one_target_check CHECK ((post_id IS NULL) <> (comment_id IS NULL));
+CREATE UNIQUE INDEX likes_user_post_key ON likes (user_id, post_id) WHERE post_id IS NOT NULL;
+CREATE UNIQUE INDEX likes_user_comment_key ON likes (user_id, comment_id) WHERE comment_id IS NOT NULL;
+
+CREATE UNIQUE INDEX users_email_key ON users (LOWER(email));
+CREATE UNIQUE INDEX users_nickname_key ON users (LOWER(nickname));
+
+-- При удалении пользователя, поста или комментария удаляется всё, что на них ссылается
+ALTER TABLE posts
+    DROP CONSTRAINT IF EXISTS posts_user_id_fkey,
+    ADD CONSTRAINT posts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE comments
+    DROP CONSTRAINT IF EXISTS comments_user_id_fkey,
+    DROP CONSTRAINT IF EXISTS comments_post_id_fkey,
+    ADD CONSTRAINT comments_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    ADD CONSTRAINT comments_post_id_fkey FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE;
+ALTER TABLE likes
+    DROP CONSTRAINT IF EXISTS likes_user_id_fkey,
+    DROP CONSTRAINT IF EXISTS likes_post_id_fkey,
+    DROP CONSTRAINT IF EXISTS likes_comment_id_fkey,
+    ADD CONSTRAINT likes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    ADD CONSTRAINT likes_post_id_fkey FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    ADD CONSTRAINT likes_comment_id_fkey FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE;
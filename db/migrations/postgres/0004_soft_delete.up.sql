@@ -0,0 +1,10 @@
+-- Мягкое удаление: удалённые пользователи, посты и комментарии помечаются deleted_at и скрываются из выдачи,
+-- а окончательно удаляются задачей очистки после окончания срока восстановления.
+-- deleted_by — кто удалил пост или комментарий: автор не может восстановить то, что удалил модератор
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE posts ADD COLUMN deleted_at TIMESTAMPTZ, ADD COLUMN deleted_by INTEGER;
+ALTER TABLE comments ADD COLUMN deleted_at TIMESTAMPTZ, ADD COLUMN deleted_by INTEGER;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX posts_deleted_at_idx ON posts (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX comments_deleted_at_idx ON comments (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Удалённые пользователи, задачи которых не завершены, после отката останутся помеченными deleted_at
+-- Заглушка не удаляется: вместе с ней удалились бы посты и комментарии анонимизированных аккаунтов
+DROP TABLE account_deletions;
@@ -0,0 +1,39 @@
+-- Задачи удаления аккаунтов и пользователь-заглушка, которому они передают посты и комментарии.
+-- После окончания срока восстановления задача удаляет лайки пользователя,
+-- передаёт его посты и комментарии автору-заглушке (anonymize) или удаляет их (erase) и удаляет сам аккаунт.
+-- user_id без внешнего ключа: задача и её состояние остаются после удаления пользователя
+CREATE TABLE account_deletions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    mode VARCHAR(16) NOT NULL CHECK (mode IN ('anonymize', 'erase')),
+    status VARCHAR(16) NOT NULL,
+    total BIGINT NOT NULL DEFAULT 0,
+    processed BIGINT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    run_after TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ
+);
+CREATE INDEX account_deletions_status_run_after_idx ON account_deletions (status, run_after);
+-- У пользователя не больше одной незавершённой задачи
+CREATE UNIQUE INDEX account_deletions_active_user_key ON account_deletions (user_id)
+    WHERE status IN ('scheduled', 'running');
+
+-- Задачи для пользователей, удалённых до этой миграции, создаёт приложение (jobs.RunAccountDeletions):
+-- срок восстановления задаётся RESTORE_WINDOW, и в SQL он неизвестен
+
+-- Пользователь-заглушка (repository.TombstoneRole). Его ник и email зарезервированы, и если их уже занял
+-- обычный пользователь, миграция останавливается: переименуйте такого пользователя и повторите миграцию
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users WHERE roles <> 'tombstone'
+               AND (LOWER(nickname) = '[deleted]' OR LOWER(email) = 'deleted@tombstone.invalid')) THEN
+        RAISE EXCEPTION 'nickname "[deleted]" or email "deleted@tombstone.invalid" is taken by a regular user; rename the user and rerun the migration';
+    END IF;
+END $$;
+
+INSERT INTO users (nickname, email, password, roles)
+SELECT '[deleted]', 'deleted@tombstone.invalid', '', 'tombstone'
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE roles = 'tombstone');
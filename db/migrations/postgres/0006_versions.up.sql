@@ -0,0 +1,4 @@
+-- Версии постов и комментариев для оптимистичной блокировки: правка сохраняется, только если версия
+-- не изменилась с момента чтения, и увеличивает её. Клиенты получают версию в ETag и передают в If-Match
+ALTER TABLE posts ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE comments ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
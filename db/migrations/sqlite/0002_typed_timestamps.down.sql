@@ -0,0 +1,3 @@
+-- В SQLite таблицы сразу создаются с created_at, updated_at и edited_at (см. 0001).
+-- Файл сохраняет общую нумерацию версий с Postgres
+SELECT 1;
@@ -0,0 +1,40 @@
+DROP INDEX users_nickname_key;
+DROP INDEX users_email_key;
+
+CREATE TABLE likes_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER REFERENCES users(id),
+    post_id INTEGER REFERENCES posts(id),
+    comment_id INTEGER REFERENCES comments(id)
+);
+INSERT INTO likes_old SELECT id, user_id, post_id, comment_id FROM likes;
+DROP TABLE likes;
+ALTER TABLE likes_old RENAME TO likes;
+
+CREATE TABLE comments_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER REFERENCES users(id),
+    post_id INTEGER REFERENCES posts(id),
+    content TEXT NOT NULL,
+    likes INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    edited_at DATETIME
+);
+INSERT INTO comments_old SELECT id, user_id, post_id, content, likes, created_at, updated_at, edited_at FROM comments;
+DROP TABLE comments;
+ALTER TABLE comments_old RENAME TO comments;
+
+CREATE TABLE posts_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER REFERENCES users(id),
+    content TEXT NOT NULL,
+    likes INTEGER NOT NULL,
+    comments INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    edited_at DATETIME
+);
+INSERT INTO posts_old SELECT id, user_id, content, likes, comments, created_at, updated_at, edited_at FROM posts;
+DROP TABLE posts;
+ALTER TABLE posts_old RENAME TO posts;
@@ -0,0 +1,56 @@
+-- Ограничения целостности, как в postgres/0003. SQLite не умеет менять внешние ключи и CHECK у существующей
+-- таблицы, поэтому posts, comments и likes пересоздаются (проверка внешних ключей на время миграции отключена, см. runMigration)
+DELETE FROM likes
+WHERE EXISTS (
+    SELECT 1 FROM likes b
+    WHERE b.id < likes.id
+      AND b.user_id = likes.user_id
+      AND b.post_id IS likes.post_id
+      AND b.comment_id IS likes.comment_id
+);
+DELETE FROM likes WHERE (post_id IS NULL) = (comment_id IS NULL);
+
+CREATE TABLE posts_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    likes INTEGER NOT NULL,
+    comments INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    edited_at DATETIME
+);
+INSERT INTO posts_new SELECT id, user_id, content, likes, comments, created_at, updated_at, edited_at FROM posts;
+DROP TABLE posts;
+ALTER TABLE posts_new RENAME TO posts;
+
+CREATE TABLE comments_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    likes INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    edited_at DATETIME
+);
+INSERT INTO comments_new SELECT id, user_id, post_id, content, likes, created_at, updated_at, edited_at FROM comments;
+DROP TABLE comments;
+ALTER TABLE comments_new RENAME TO comments;
+
+CREATE TABLE likes_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    CONSTRAINT likes_one_target_check CHECK ((post_id IS NULL) <> (comment_id IS NULL))
+);
+INSERT INTO likes_new SELECT id, user_id, post_id, comment_id FROM likes;
+DROP TABLE likes;
+ALTER TABLE likes_new RENAME TO likes;
+
+CREATE UNIQUE INDEX likes_user_post_key ON likes (user_id, post_id) WHERE post_id IS NOT NULL;
+CREATE UNIQUE INDEX likes_user_comment_key ON likes (user_id, comment_id) WHERE comment_id IS NOT NULL;
+
+CREATE UNIQUE INDEX users_email_key ON users (LOWER(email));
+CREATE UNIQUE INDEX users_nickname_key ON users (LOWER(nickname));
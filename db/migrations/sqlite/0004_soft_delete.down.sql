@@ -0,0 +1,10 @@
+-- Записи, которые ещё ждут очистки, после отката снова станут видны
+DROP INDEX comments_deleted_at_idx;
+DROP INDEX posts_deleted_at_idx;
+DROP INDEX users_deleted_at_idx;
+
+ALTER TABLE comments DROP COLUMN deleted_by;
+ALTER TABLE comments DROP COLUMN deleted_at;
+ALTER TABLE posts DROP COLUMN deleted_by;
+ALTER TABLE posts DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN deleted_at;
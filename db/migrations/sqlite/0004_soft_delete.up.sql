@@ -0,0 +1,10 @@
+-- Мягкое удаление, как в postgres/0004_soft_delete.up.sql
+ALTER TABLE users ADD COLUMN deleted_at DATETIME;
+ALTER TABLE posts ADD COLUMN deleted_at DATETIME;
+ALTER TABLE posts ADD COLUMN deleted_by INTEGER;
+ALTER TABLE comments ADD COLUMN deleted_at DATETIME;
+ALTER TABLE comments ADD COLUMN deleted_by INTEGER;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX posts_deleted_at_idx ON posts (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX comments_deleted_at_idx ON comments (deleted_at) WHERE deleted_at IS NOT NULL;
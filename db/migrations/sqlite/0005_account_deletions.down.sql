@@ -0,0 +1,2 @@
+-- Заглушка не удаляется, как в postgres/0005_account_deletions.down.sql
+DROP TABLE account_deletions;
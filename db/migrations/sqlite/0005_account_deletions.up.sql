@@ -0,0 +1,33 @@
+-- Задачи удаления аккаунтов и пользователь-заглушка, как в postgres/0005_account_deletions.up.sql
+CREATE TABLE account_deletions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    mode VARCHAR(16) NOT NULL CHECK (mode IN ('anonymize', 'erase')),
+    status VARCHAR(16) NOT NULL,
+    total INTEGER NOT NULL DEFAULT 0,
+    processed INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    run_after DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at DATETIME,
+    finished_at DATETIME
+);
+CREATE INDEX account_deletions_status_run_after_idx ON account_deletions (status, run_after);
+CREATE UNIQUE INDEX account_deletions_active_user_key ON account_deletions (user_id)
+    WHERE status IN ('scheduled', 'running');
+
+-- В SQLite нет RAISE вне триггеров, поэтому занятые ник или email заглушки проверяет временный триггер
+CREATE TEMP TRIGGER tombstone_user_check BEFORE INSERT ON users
+WHEN NEW.roles = 'tombstone' AND EXISTS (SELECT 1 FROM users WHERE roles <> 'tombstone'
+    AND (LOWER(nickname) = '[deleted]' OR LOWER(email) = 'deleted@tombstone.invalid'))
+BEGIN
+    SELECT RAISE(ABORT, 'nickname "[deleted]" or email "deleted@tombstone.invalid" is taken by a regular user; rename the user and rerun the migration');
+END;
+
+INSERT INTO users (nickname, email, password, roles, created_at, updated_at)
+SELECT '[deleted]', 'deleted@tombstone.invalid', '', 'tombstone',
+    strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'), strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE roles = 'tombstone');
+
+DROP TRIGGER tombstone_user_check;
@@ -0,0 +1,3 @@
+-- Версии постов и комментариев, как в postgres/0006_versions.up.sql
+ALTER TABLE posts ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE comments ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
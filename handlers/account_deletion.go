@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Блок задач удаления аккаунтов
+
+// GetAccountDeletion показывает состояние задачи удаления аккаунта. Токены удалённого пользователя отозваны,
+// поэтому задача доступна без авторизации по случайному идентификатору из ответа на удаление
+func (h *Handler) GetAccountDeletion(c *gin.Context) {
+	deletion, err := h.AccountDeletions.GetByID(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Account deletion not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(200, accountDeletionResponse(deletion))
+}
+
+// accountDeletionResponse формирует ответ с состоянием задачи. Пользователь в ответ не попадает
+func accountDeletionResponse(deletion *models.AccountDeletion) gin.H {
+	return gin.H{
+		"id":          deletion.ID,
+		"mode":        deletion.Mode,
+		"status":      deletion.Status,
+		"total":       deletion.Total,
+		"processed":   deletion.Processed,
+		"error":       deletion.Error,
+		"run_after":   formatTime(deletion.RunAfter),
+		"started_at":  formatOptionalTime(deletion.StartedAt),
+		"finished_at": formatOptionalTime(deletion.FinishedAt),
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"apiForSN/auth"
+	"apiForSN/jobs"
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Максимальное количество записей журнала в одном ответе
+const maxAdminActionsPage = 100
+
+// Блок администрирования
+func (h *Handler) UpdateUserRoles(c *gin.Context) {
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var request struct {
+		Roles []string `json:"roles"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || len(request.Roles) == 0 {
+		c.JSON(400, gin.H{"error": "roles are required"})
+		return
+	}
+	for _, role := range request.Roles {
+		if !auth.ValidRole(role) {
+			c.JSON(400, gin.H{"error": "Unknown role: " + role})
+			return
+		}
+	}
+
+	user, err := h.Users.GetByID(targetID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+
+	previousRoles := strings.Join(user.Roles, ",")
+	user.Roles = models.StringList(request.Roles)
+	err = h.Transaction(func(tx repository.Repositories) error {
+		if err := tx.Users.Update(user, repository.FieldRoles); err != nil {
+			return err
+		}
+		return recordAdminAction(tx, c, "user.roles", "user", user.ID, previousRoles+" -> "+strings.Join(request.Roles, ","))
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update roles"})
+		return
+	}
+
+	// Роли зашиты в токены, поэтому отзываем выданные токены: новые будут выпущены уже с новыми ролями
+	if err := auth.RevokeAllUserTokens(user.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke existing tokens"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"id":    user.ID,
+		"roles": request.Roles,
+	})
+}
+
+// UnlockUser снимает блокировку входа, наложенную после неудачных попыток
+func (h *Handler) UnlockUser(c *gin.Context) {
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.Users.GetByID(targetID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := auth.LoginLockout.Unlock(user.Email); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	if err := recordAdminAction(h.Repositories, c, "user.unlock", "user", user.ID, ""); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to record admin action"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "User unlocked successfully"})
+}
+
+// ListAdminActions возвращает последние записи журнала действий администраторов
+func (h *Handler) ListAdminActions(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > maxAdminActionsPage {
+		limit = maxAdminActionsPage
+	}
+
+	actions, err := h.AdminActions.List(limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(200, gin.H{"actions": actions})
+}
+
+// ReconcileCounters пересчитывает счётчики лайков и комментариев. С dry_run=true только показывает,
+// сколько строк с расхождением будет исправлено
+func (h *Handler) ReconcileCounters(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid dry_run value"})
+		return
+	}
+
+	report, err := jobs.ReconcileCounters(h.Repositories, dryRun)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reconcile counters"})
+		return
+	}
+	if report.Corrected() > 0 {
+		details := fmt.Sprintf("posts=%d comments=%d", report.Posts, report.Comments)
+		if err := recordAdminAction(h.Repositories, c, "counters.reconcile", "counters", 0, details); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to record admin action"})
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"dry_run":   report.DryRun,
+		"posts":     report.Posts,
+		"comments":  report.Comments,
+		"corrected": report.Corrected(),
+	})
+}
+
+// canModerate сообщает, может ли текущий пользователь изменять чужие посты и комментарии
+func canModerate(c *gin.Context) bool {
+	return auth.HasScope(c.GetStringSlice("scopes"), auth.ScopeModerate)
+}
+
+// recordAdminAction записывает действие модератора или администратора в журнал через репозитории tx,
+// обычно внутри транзакции вместе с самим действием
+func recordAdminAction(tx repository.Repositories, c *gin.Context, action, targetType string, targetID int, details string) error {
+	record := models.AdminAction{
+		ActorID:    c.GetInt("userID"),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+	}
+	return tx.AdminActions.Create(&record)
+}
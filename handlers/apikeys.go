@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"apiForSN/auth"
+	"apiForSN/models"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ограничения на ключи API
+const (
+	maxAPIKeysPerUser   = 20
+	maxAPIKeyNameLength = 100
+)
+
+// Блок работы с ключами API
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var request struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days,omitempty"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		c.JSON(400, gin.H{"error": "Name must be between 1 and 100 characters long"})
+		return
+	}
+	if len(request.Scopes) == 0 {
+		c.JSON(400, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	if request.ExpiresInDays < 0 {
+		c.JSON(400, gin.H{"error": "expires_in_days must be positive"})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	// Ключ не может получить больше прав, чем есть у владельца
+	for _, scope := range request.Scopes {
+		if !auth.APIKeyScopeAllowed(scope, user.Roles) {
+			c.JSON(400, gin.H{"error": "Scope is not allowed: " + scope})
+			return
+		}
+	}
+
+	count, err := h.APIKeys.CountActive(user.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if count >= maxAPIKeysPerUser {
+		c.JSON(400, gin.H{"error": "Too many API keys, revoke unused ones first"})
+		return
+	}
+
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	apiKey := models.APIKey{
+		UserID:  user.ID,
+		Name:    name,
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  models.StringList(request.Scopes),
+	}
+	if request.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := h.APIKeys.Create(&apiKey); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	// Сам ключ возвращается только в этом ответе
+	c.JSON(201, gin.H{
+		"id":         apiKey.ID,
+		"name":       apiKey.Name,
+		"prefix":     apiKey.Prefix,
+		"scopes":     apiKey.Scopes,
+		"expires_at": apiKey.ExpiresAt,
+		"key":        key,
+	})
+}
+
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.APIKeys.ListActive(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(200, gin.H{"keys": keys})
+}
+
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid key ID"})
+		return
+	}
+
+	revoked, err := h.APIKeys.Revoke(keyID, c.GetInt("userID"), time.Now())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if !revoked {
+		c.JSON(404, gin.H{"error": "API key not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "API key revoked successfully"})
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"apiForSN/auth"
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Единое сообщение для любой неудачной попытки входа, чтобы не раскрывать, существует ли email
+const invalidCredentialsMessage = "Invalid email or password"
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash возвращает хеш-заглушку тем же алгоритмом и той же стоимости, что и настоящие пароли.
+// Сравнение с ним выравнивает время ответа для несуществующих email
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		hash, err := hashPassword("dummy-password-for-timing")
+		if err == nil {
+			dummyHash = hash
+		}
+	})
+	return dummyHash
+}
+
+// Блок аутентификации
+func (h *Handler) Login(c *gin.Context) {
+	var credentials struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if credentials.Email == "" || credentials.Password == "" {
+		c.JSON(400, gin.H{"error": "Email and password are required"})
+		return
+	}
+
+	// Блокировка проверяется до сверки пароля и одинаково для существующих и несуществующих email
+	email := strings.ToLower(strings.TrimSpace(credentials.Email))
+	if !checkLockout(c, email) {
+		return
+	}
+
+	// Ищем пользователя по email
+	user, err := h.Users.GetByEmail(email)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if err != nil {
+		// Пользователь не найден: всё равно выполняем сравнение, чтобы время ответа не выдавало отсутствие email
+		auth.Passwords.Verify(dummyPasswordHash(), credentials.Password)
+		recordFailedAttempt(c, email)
+		c.JSON(401, gin.H{"error": invalidCredentialsMessage})
+		return
+	}
+
+	// Сверяем пароль с сохранённым хешем
+	valid, needsRehash, err := auth.Passwords.Verify(user.Password, credentials.Password)
+	if err != nil {
+		log.Printf("Failed to verify password of user %d: %v", user.ID, err)
+	}
+	if !valid {
+		recordFailedAttempt(c, email)
+		c.JSON(401, gin.H{"error": invalidCredentialsMessage})
+		return
+	}
+
+	// Хеш с устаревшим алгоритмом или параметрами пересчитываем, пока пароль известен в открытом виде
+	if needsRehash {
+		h.upgradePasswordHash(*user, credentials.Password)
+	}
+
+	// При включённой 2FA пароль — только первый шаг: выдаём промежуточный токен вместо полноценных.
+	// Счётчик неудач сбрасывается только после второго фактора, чтобы верный пароль не обнулял перебор кодов
+	if user.TOTPEnabled {
+		mfaToken, expiresAt, err := auth.GenerateMFAToken(user.ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"mfa_required":   true,
+			"mfa_token":      mfaToken,
+			"mfa_expires_at": expiresAt.UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	recordSuccessfulAttempt(email)
+	respondWithToken(c, 200, *user, nil)
+}
+
+// Refresh обменивает refresh-токен на новую пару токенов. Каждый refresh-токен одноразовый
+func (h *Handler) Refresh(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.RefreshToken == "" {
+		c.JSON(400, gin.H{"error": "refresh_token is required"})
+		return
+	}
+
+	refresh, err := auth.RotateRefreshToken(request.RefreshToken, clientInfo(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrRefreshTokenReused):
+			c.JSON(401, gin.H{"error": "Refresh token has already been used, all sessions of this login were revoked"})
+		case errors.Is(err, auth.ErrInvalidRefreshToken):
+			c.JSON(401, gin.H{"error": "Invalid or expired refresh token"})
+		default:
+			c.JSON(500, gin.H{"error": "Failed to refresh token"})
+		}
+		return
+	}
+
+	// Роли читаем заново, чтобы изменения ролей попадали в новые токены
+	user, err := h.Users.GetByID(refresh.UserID)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	accessToken, expiresAt, err := auth.GenerateJWT(user.ID, user.Roles, refresh.SessionID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(200, tokenResponse(accessToken, expiresAt, refresh))
+}
+
+// Logout отзывает текущий access-токен и сессию этого устройства вместе с её refresh-токенами
+func (h *Handler) Logout(c *gin.Context) {
+	value, _ := c.Get("claims")
+	claims, ok := value.(*auth.Claims)
+	if !ok {
+		c.JSON(401, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Тело запроса необязательно
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	_ = c.ShouldBindJSON(&request)
+
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := auth.Revocations.RevokeToken(claims.ID, claims.UserID, expiresAt); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	if claims.SessionID != "" {
+		if err := auth.RevokeSession(claims.UserID, claims.SessionID); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+			c.JSON(500, gin.H{"error": "Failed to revoke session"})
+			return
+		}
+	}
+	if request.RefreshToken != "" {
+		if err := auth.RevokeRefreshToken(claims.UserID, request.RefreshToken); err != nil && !errors.Is(err, auth.ErrInvalidRefreshToken) {
+			c.JSON(500, gin.H{"error": "Failed to revoke refresh token"})
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll отзывает все токены пользователя на всех устройствах
+func (h *Handler) LogoutAll(c *gin.Context) {
+	userID := c.GetInt("userID")
+	if err := auth.RevokeAllUserTokens(userID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Logged out from all devices"})
+}
+
+// JWKS отдаёт публичные ключи подписи, чтобы другие сервисы могли проверять токены без общего секрета
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, auth.PublicJWKS())
+}
+
+// upgradePasswordHash перехеширует пароль текущими параметрами. Условие по старому хешу не даёт
+// затереть пароль, если его успели сменить параллельно. Ошибка не мешает входу
+func (h *Handler) upgradePasswordHash(user models.User, password string) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", user.ID, err)
+		return
+	}
+	if _, err := h.Users.ReplacePassword(user.ID, user.Password, hash); err != nil {
+		log.Printf("Failed to store rehashed password of user %d: %v", user.ID, err)
+	}
+}
+
+// checkLockout отвечает 429, если аккаунт или IP клиента временно заблокированы после неудачных попыток.
+// Возвращает false, если ответ уже отправлен
+func checkLockout(c *gin.Context, email string) bool {
+	wait, err := auth.LoginLockout.Check(email, c.ClientIP())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to check login attempts"})
+		return false
+	}
+	if wait > 0 {
+		respondTooManyRequests(c, wait, "Too many failed attempts, try again later")
+		return false
+	}
+	return true
+}
+
+// respondTooManyRequests отвечает 429 с временем ожидания в Retry-After и в теле ответа
+func respondTooManyRequests(c *gin.Context, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(429, gin.H{"error": message, "retry_after": seconds})
+}
+
+// recordFailedAttempt учитывает неудачную проверку учётных данных для аккаунта и IP клиента
+func recordFailedAttempt(c *gin.Context, email string) {
+	if err := auth.LoginLockout.RecordFailure(email, c.ClientIP()); err != nil {
+		log.Printf("Failed to record failed login attempt: %v", err)
+	}
+}
+
+// recordSuccessfulAttempt сбрасывает счётчик неудач аккаунта
+func recordSuccessfulAttempt(email string) {
+	if err := auth.LoginLockout.RecordSuccess(email); err != nil {
+		log.Printf("Failed to reset login attempts: %v", err)
+	}
+}
+
+// respondWithToken выпускает access- и refresh-токены для пользователя и отправляет их вместе с дополнительными полями ответа
+func respondWithToken(c *gin.Context, status int, user models.User, extra gin.H) {
+	// Сначала открываем сессию, чтобы привязать к ней access-токен
+	refresh, err := auth.IssueRefreshToken(user.ID, clientInfo(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate refresh token"})
+		return
+	}
+	accessToken, expiresAt, err := auth.GenerateJWT(user.ID, user.Roles, refresh.SessionID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	response := tokenResponse(accessToken, expiresAt, refresh)
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(status, response)
+}
+
+// tokenResponse формирует тело ответа с парой токенов
+func tokenResponse(accessToken string, expiresAt time.Time, refresh *auth.IssuedRefreshToken) gin.H {
+	return gin.H{
+		"token":              accessToken,
+		"token_type":         "Bearer",
+		"expires_at":         expiresAt.UTC().Format(time.RFC3339),
+		"refresh_token":      refresh.Token,
+		"refresh_expires_at": refresh.ExpiresAt.UTC().Format(time.RFC3339),
+		"session_id":         refresh.SessionID,
+	}
+}
+
+// clientInfo собирает сведения об устройстве клиента для записи в сессию
+func clientInfo(c *gin.Context) auth.ClientInfo {
+	return auth.ClientInfo{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
@@ -0,0 +1,212 @@
+package handlers_test
+
+import (
+	"apiForSN/auth"
+	"apiForSN/repository"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// totpCode вычисляет код TOTP для интервала step, как приложение-аутентификатор
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestLogoutRejectsOldTokens(t *testing.T) {
+	s := newTestServer(t)
+	s.signup("alice", "alice@example.com")
+	session := s.login("alice@example.com")
+	token, refresh := session["token"].(string), session["refresh_token"].(string)
+
+	if code, response := s.do("POST", "/auth/logout", token, `{"refresh_token":"`+refresh+`"}`); code != 200 {
+		t.Fatalf("logout: status %d, body %v", code, response)
+	}
+	if code, _ := s.do("GET", "/api/user", token, ""); code != 401 {
+		t.Errorf("access token after logout: status %d, want 401", code)
+	}
+	if code, _ := s.do("POST", "/auth/refresh", "", `{"refresh_token":"`+refresh+`"}`); code != 401 {
+		t.Errorf("refresh token after logout: status %d, want 401", code)
+	}
+}
+
+func TestLogoutAllRejectsOldTokens(t *testing.T) {
+	s := newTestServer(t)
+	s.signup("alice", "alice@example.com")
+	phone := s.login("alice@example.com")
+	laptop := s.login("alice@example.com")
+
+	if code, response := s.do("POST", "/auth/logout-all", phone["token"].(string), ""); code != 200 {
+		t.Fatalf("logout-all: status %d, body %v", code, response)
+	}
+	for name, session := range map[string]map[string]interface{}{"phone": phone, "laptop": laptop} {
+		if code, _ := s.do("GET", "/api/user", session["token"].(string), ""); code != 401 {
+			t.Errorf("%s access token: status %d, want 401", name, code)
+		}
+		if code, _ := s.do("POST", "/auth/refresh", "", `{"refresh_token":"`+session["refresh_token"].(string)+`"}`); code != 401 {
+			t.Errorf("%s refresh token: status %d, want 401", name, code)
+		}
+	}
+
+	// Вход сразу после выхода везде, в ту же секунду, выдаёт рабочий токен
+	fresh := s.login("alice@example.com")
+	if code, response := s.do("GET", "/api/user", fresh["token"].(string), ""); code != 200 {
+		t.Errorf("token issued after logout-all: status %d, body %v", code, response)
+	}
+}
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	s := newTestServer(t)
+	s.signup("alice", "alice@example.com")
+	session := s.login("alice@example.com")
+	first := session["refresh_token"].(string)
+
+	code, rotated := s.do("POST", "/auth/refresh", "", `{"refresh_token":"`+first+`"}`)
+	if code != 200 {
+		t.Fatalf("refresh: status %d, body %v", code, rotated)
+	}
+	// Повторный обмен погашенного токена отзывает сессию целиком: и новый refresh-, и новый access-токен
+	if code, _ := s.do("POST", "/auth/refresh", "", `{"refresh_token":"`+first+`"}`); code != 401 {
+		t.Errorf("reused refresh token: status %d, want 401", code)
+	}
+	if code, _ := s.do("POST", "/auth/refresh", "", `{"refresh_token":"`+rotated["refresh_token"].(string)+`"}`); code != 401 {
+		t.Errorf("refresh token rotated before reuse: status %d, want 401", code)
+	}
+	if code, _ := s.do("GET", "/api/user", rotated["token"].(string), ""); code != 401 {
+		t.Errorf("access token of the revoked session: status %d, want 401", code)
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	s := newTestServer(t)
+	token := s.signup("alice", "alice@example.com")
+
+	code, enrollment := s.do("POST", "/api/user/2fa/enroll", token, "")
+	if code != 200 {
+		t.Fatalf("enroll: status %d, body %v", code, enrollment)
+	}
+	secret := enrollment["secret"].(string)
+	recoveryCodes := enrollment["recovery_codes"].([]interface{})
+	step := time.Now().Unix() / 30
+	if code, response := s.do("POST", "/api/user/2fa/enable", token, `{"code":"`+totpCode(t, secret, step)+`"}`); code != 200 {
+		t.Fatalf("enable: status %d, body %v", code, response)
+	}
+
+	mfaLogin := func(body string) (int, map[string]interface{}) {
+		t.Helper()
+		response := s.login("alice@example.com")
+		mfaToken, _ := response["mfa_token"].(string)
+		if mfaToken == "" {
+			t.Fatalf("login with 2FA: no mfa_token in %v", response)
+		}
+		return s.do("POST", "/auth/login/mfa", "", `{"mfa_token":"`+mfaToken+`",`+body+`}`)
+	}
+
+	// Код, которым подтверждали подключение, уже использован
+	if code, _ := mfaLogin(`"code":"` + totpCode(t, secret, step) + `"`); code != 401 {
+		t.Errorf("replayed TOTP code: status %d, want 401", code)
+	}
+	next := `"code":"` + totpCode(t, secret, step+1) + `"`
+	if code, response := mfaLogin(next); code != 200 {
+		t.Errorf("fresh TOTP code: status %d, body %v", code, response)
+	}
+	if code, _ := mfaLogin(next); code != 401 {
+		t.Errorf("TOTP code reused: status %d, want 401", code)
+	}
+
+	// Промежуточный токен одноразовый: второй обмен не проходит даже с другим верным кодом
+	mfaToken := s.login("alice@example.com")["mfa_token"].(string)
+	withRecoveryCode := func(i int) string {
+		return `{"mfa_token":"` + mfaToken + `","recovery_code":"` + recoveryCodes[i].(string) + `"}`
+	}
+	if code, response := s.do("POST", "/auth/login/mfa", "", withRecoveryCode(0)); code != 200 {
+		t.Errorf("recovery code: status %d, body %v", code, response)
+	}
+	if code, _ := s.do("POST", "/auth/login/mfa", "", withRecoveryCode(1)); code != 401 {
+		t.Errorf("mfa_token reused: status %d, want 401", code)
+	}
+
+	// Код восстановления тоже одноразовый, а неиспользованные коды продолжают работать
+	if code, _ := mfaLogin(`"recovery_code":"` + recoveryCodes[0].(string) + `"`); code != 401 {
+		t.Errorf("recovery code reused: status %d, want 401", code)
+	}
+	if code, response := mfaLogin(`"recovery_code":"` + recoveryCodes[1].(string) + `"`); code != 200 {
+		t.Errorf("unused recovery code: status %d, body %v", code, response)
+	}
+}
+
+func TestLockoutAndAdminUnlock(t *testing.T) {
+	s := newTestServer(t)
+	s.signup("alice", "alice@example.com")
+	s.signup("admin", "admin@example.com")
+	admin, err := s.repos.Users.GetByEmail("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin.Roles = []string{auth.RoleAdmin}
+	if err := s.repos.Users.Update(admin, repository.FieldRoles); err != nil {
+		t.Fatal(err)
+	}
+	adminToken := s.login("admin@example.com")["token"].(string)
+
+	wrong := `{"email":"alice@example.com","password":"Wrong123!"}`
+	for i := 0; i < auth.DefaultAccountPolicy.FreeAttempts; i++ {
+		if code, _ := s.do("POST", "/auth/login", "", wrong); code != 401 {
+			t.Fatalf("attempt %d: status %d, want 401", i+1, code)
+		}
+	}
+	// Следующая неудача блокирует аккаунт, и даже верный пароль получает 429 с временем ожидания
+	s.do("POST", "/auth/login", "", wrong)
+	code, headers, response := s.doWithHeaders("POST", "/auth/login", "", `{"email":"alice@example.com","password":"Secret123!"}`, nil)
+	if code != 429 {
+		t.Fatalf("locked account: status %d, body %v", code, response)
+	}
+	if headers.Get("Retry-After") != fmt.Sprint(auth.DefaultAccountPolicy.BaseDelay.Seconds()) {
+		t.Errorf("Retry-After = %q, want %v", headers.Get("Retry-After"), auth.DefaultAccountPolicy.BaseDelay.Seconds())
+	}
+
+	alice, err := s.repos.Users.GetByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, response := s.do("POST", fmt.Sprintf("/api/admin/users/%d/unlock", alice.ID), adminToken, ""); code != 200 {
+		t.Fatalf("unlock: status %d, body %v", code, response)
+	}
+	s.login("alice@example.com")
+}
+
+func TestLoginRehashesOutdatedPassword(t *testing.T) {
+	s := newTestServer(t)
+	s.signup("alice", "alice@example.com")
+
+	auth.Passwords.BcryptCost = bcrypt.MinCost + 1
+	defer func() { auth.Passwords.BcryptCost = bcrypt.MinCost }()
+	s.login("alice@example.com")
+
+	user, err := s.repos.Users.GetByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, err := bcrypt.Cost([]byte(user.Password)); err != nil || cost != bcrypt.MinCost+1 {
+		t.Errorf("cost after login = %d, err = %v, want %d", cost, err, bcrypt.MinCost+1)
+	}
+	// Пересчитанный хеш подходит к тому же паролю
+	s.login("alice@example.com")
+}
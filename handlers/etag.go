@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Блок условных запросов: версия поста или комментария отдаётся в ETag,
+// а правка с устаревшей версией в If-Match отклоняется с 412
+
+// setETag отдаёт версию записи в заголовке ETag
+func setETag(c *gin.Context, version int) {
+	c.Header("ETag", etag(version))
+}
+
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// checkIfMatch сверяет заголовок If-Match с версией записи. Запрос без заголовка проходит проверку.
+// При несовпадении отвечает 412 и возвращает false
+func checkIfMatch(c *gin.Context, version int) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	current := etag(version)
+	// If-Match сравнивает ETag строго, поэтому слабые (W/"...") никогда не совпадают
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == current {
+			return true
+		}
+	}
+	respondPreconditionFailed(c, version)
+	return false
+}
+
+// respondPreconditionFailed отвечает 412 с ETag текущей версии записи
+func respondPreconditionFailed(c *gin.Context, version int) {
+	setETag(c, version)
+	c.JSON(412, gin.H{"error": "Record has been modified, reload it and retry"})
+}
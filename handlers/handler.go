@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"apiForSN/repository"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler обрабатывает запросы API. Данные читаются и сохраняются только через репозитории
+type Handler struct {
+	repository.Repositories
+}
+
+// New создаёт обработчики поверх указанных репозиториев
+func New(repos repository.Repositories) *Handler {
+	return &Handler{Repositories: repos}
+}
+
+// respondConstraintError отвечает 409, если запись нарушила ограничение базы (уникальность, внешний ключ).
+// Для остальных ошибок ничего не отправляет и возвращает false
+func respondConstraintError(c *gin.Context, err error) bool {
+	switch {
+	case repository.ViolatedConstraint(err) == repository.ConstraintUserEmail:
+		c.JSON(409, gin.H{"error": "User with this email already exists"})
+	case repository.ViolatedConstraint(err) == repository.ConstraintUserNickname:
+		c.JSON(409, gin.H{"error": "Nickname is already taken"})
+	case errors.Is(err, repository.ErrDuplicate):
+		c.JSON(409, gin.H{"error": "Record already exists"})
+	case errors.Is(err, repository.ErrForeignKey):
+		c.JSON(409, gin.H{"error": "Referenced record no longer exists"})
+	default:
+		return false
+	}
+	return true
+}
+
+// constraintOr возвращает из транзакции ошибку ограничения как есть, чтобы respondConstraintError ответил 409,
+// а остальные ошибки заменяет сообщением message для ответа 500
+func constraintOr(err error, message string) error {
+	if errors.Is(err, repository.ErrDuplicate) || errors.Is(err, repository.ErrForeignKey) {
+		return err
+	}
+	return errors.New(message)
+}
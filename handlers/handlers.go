@@ -1,66 +1,109 @@
 package handlers
 
 import (
-	"apiForSN/db"
+	"apiForSN/auth"
+	"apiForSN/jobs"
 	"apiForSN/models"
+	"apiForSN/repository"
 	"errors"
+	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
 // Блок работы с юзером
-func CreateUser(c *gin.Context) {
-	var user models.User
-	// Привязываем JSON-данные из тела запроса к переменной user
-	if err := c.ShouldBindJSON(&user); err != nil {
+func (h *Handler) CreateUser(c *gin.Context) {
+	// Пароль в models.User скрыт из JSON, поэтому принимаем данные регистрации в отдельную структуру
+	var signup struct {
+		Email    string `json:"email"`
+		Nickname string `json:"nickname"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&signup); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// Проверяем формат email, длину никнейма и надёжность пароля
+	email, err := normalizeEmail(signup.Email)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	nickname := strings.TrimSpace(signup.Nickname)
+	if err := validateNickname(nickname); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePassword(signup.Password); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Проверяем уникальность email, чтобы не создать дублирующегося пользователя
-	var existingUser models.User
-	if err := db.DB.Where("email = ?", user.Email).First(&existingUser).Error; err == nil {
-		c.JSON(400, gin.H{"error": "User with this email already exists"})
+	// Занятый email проверяем заранее, чтобы не хешировать пароль зря. Окончательно уникальность
+	// email и никнейма гарантируют индексы базы: при одновременной регистрации Create вернёт ошибку ограничения
+	taken, err := h.Users.EmailTaken(email, 0)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if taken {
+		c.JSON(409, gin.H{"error": "User with this email already exists"})
 		return
 	}
 
 	// Хешируем пароль перед сохранением
-	hashedPassword, err := hashPassword(user.Password)
+	hashedPassword, err := hashPassword(signup.Password)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Error hashing password"})
 		return
 	}
-	user.Password = hashedPassword
+	user := models.User{
+		Nickname: nickname,
+		Email:    email,
+		Password: hashedPassword,
+		Roles:    models.StringList{auth.RoleUser},
+	}
 
 	// Сохраняем пользователя в базе данных
-	if err := db.DB.Create(&user).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Error creating user"})
+	if err := h.Users.Create(&user); err != nil {
+		if !respondConstraintError(c, err) {
+			c.JSON(500, gin.H{"error": "Error creating user"})
+		}
 		return
 	}
 
-	// Возвращаем успешный ответ с данными о созданном пользователе (без пароля)
-	c.JSON(201, gin.H{
-		"id":       user.ID,
-		"nickname": user.Nickname,
-		"email":    user.Email,
+	// Отправляем письмо для подтверждения email. Ошибка отправки не мешает регистрации: письмо можно запросить повторно
+	if err := sendVerificationEmail(user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
+	// Возвращаем данные о созданном пользователе (без пароля) вместе с токеном, чтобы клиенту не нужен был отдельный вход
+	respondWithToken(c, 201, user, gin.H{
+		"id":             user.ID,
+		"nickname":       user.Nickname,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
 	})
 }
 
-func GetUser(c *gin.Context) {
-	// Получаем ID пользователя из параметров URL
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid user ID"})
-		return
+func (h *Handler) GetUser(c *gin.Context) {
+	// Получаем ID пользователя из параметров URL, а на маршруте /user — из контекста
+	id := c.GetInt("userID")
+	if param := c.Param("id"); param != "" {
+		var err error
+		if id, err = strconv.Atoi(param); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
+			return
+		}
 	}
 	// Проверяем наличие пользователя с таким ID
-	var existingUser models.User
-	if err := db.DB.Where("id = ?", id).First(&existingUser).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	existingUser, err := h.Users.GetByID(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			// Пользователь с таким email не найден
 			c.JSON(404, gin.H{"error": "User not found"})
 			return
@@ -70,13 +113,14 @@ func GetUser(c *gin.Context) {
 		}
 	}
 	c.JSON(200, gin.H{
-		"id":       existingUser.ID,
-		"nickname": existingUser.Nickname,
-		"email":    existingUser.Email,
+		"id":             existingUser.ID,
+		"nickname":       existingUser.Nickname,
+		"email":          existingUser.Email,
+		"email_verified": existingUser.EmailVerified,
 	})
 }
 
-func UpdateUser(c *gin.Context) {
+func (h *Handler) UpdateUser(c *gin.Context) {
 	// Получаем ID пользователя из контекста
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -89,15 +133,17 @@ func UpdateUser(c *gin.Context) {
 		Email    string `json:"email,omitempty"`
 		Nickname string `json:"nickname,omitempty"`
 		Password string `json:"password,omitempty"`
+		// Текущий пароль обязателен при смене пароля
+		CurrentPassword string `json:"current_password,omitempty"`
 	}
 	if err := c.ShouldBindJSON(&updateData); err != nil {
 		c.JSON(400, gin.H{"error": "Invalid request body"})
 		return
 	}
 	// Ищем пользователя в базе данных по ID
-	var user models.User
-	if err := db.DB.Where("id = ?", userID).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	user, err := h.Users.GetByID(userID.(int))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			// Пользователь с таким id не найден
 			c.JSON(404, gin.H{"error": "User not found"})
 			return
@@ -107,39 +153,116 @@ func UpdateUser(c *gin.Context) {
 		}
 	}
 	// Обновляем только те поля, которые присутствуют в запросе
-	updates := make(map[string]interface{})
+	var fields []string
+	var newEmail, newNickname, newPassword string
+	emailChanged := false
 	if updateData.Email != "" {
-		updates["email"] = updateData.Email
+		email, err := normalizeEmail(updateData.Email)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if email != user.Email {
+			taken, err := h.Users.EmailTaken(email, user.ID)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Database error"})
+				return
+			}
+			if taken {
+				c.JSON(409, gin.H{"error": "User with this email already exists"})
+				return
+			}
+			// Новый адрес нужно подтвердить заново
+			newEmail = email
+			fields = append(fields, repository.FieldEmail, repository.FieldEmailVerified)
+			emailChanged = true
+		}
 	}
 	if updateData.Nickname != "" {
-		updates["nickname"] = updateData.Nickname
+		nickname := strings.TrimSpace(updateData.Nickname)
+		if err := validateNickname(nickname); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		newNickname = nickname
+		fields = append(fields, repository.FieldNickname)
 	}
 	if updateData.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(updateData.Password), bcrypt.DefaultCost)
+		// Проверка текущего пароля подчиняется той же блокировке, что и вход
+		if updateData.CurrentPassword == "" {
+			c.JSON(400, gin.H{"error": "current_password is required to change the password"})
+			return
+		}
+		if !checkLockout(c, user.Email) {
+			return
+		}
+		if !verifyPassword(*user, updateData.CurrentPassword) {
+			recordFailedAttempt(c, user.Email)
+			c.JSON(401, gin.H{"error": "Invalid current password"})
+			return
+		}
+		recordSuccessfulAttempt(user.Email)
+
+		if err := validatePassword(updateData.Password); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		hashedPassword, err := hashPassword(updateData.Password)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to hash password"})
 			return
 		}
-		updates["password"] = string(hashedPassword)
+		newPassword = hashedPassword
+		fields = append(fields, repository.FieldPassword)
 	}
 
 	// Выполняем обновление в базе данных
-	if len(updates) > 0 {
-		if err := db.DB.Model(&user).Updates(updates).Error; err != nil {
+	if emailChanged {
+		user.Email = newEmail
+		user.EmailVerified = false
+	}
+	if newNickname != "" {
+		user.Nickname = newNickname
+	}
+	if newPassword != "" {
+		user.Password = newPassword
+	}
+	if err := h.Users.Update(user, fields...); err != nil {
+		if !respondConstraintError(c, err) {
 			c.JSON(500, gin.H{"error": "Failed to update user"})
+		}
+		return
+	}
+
+	// Отправляем письмо для подтверждения нового адреса
+	if emailChanged {
+		if err := sendVerificationEmail(*user); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}
+
+	response := gin.H{
+		"id":             user.ID,
+		"nickname":       user.Nickname,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}
+
+	// После смены пароля отзываем все выданные токены и выдаём текущему клиенту новую пару
+	if updateData.Password != "" {
+		if err := auth.RevokeAllUserTokens(user.ID); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to revoke existing tokens"})
 			return
 		}
+		respondWithToken(c, 200, *user, response)
+		return
 	}
 
 	// Возвращаем обновленную информацию о пользователе
-	c.JSON(200, gin.H{
-		"id":       user.ID,
-		"nickname": user.Nickname,
-		"email":    user.Email,
-	})
+	c.JSON(200, response)
 }
 
-func DeleteUser(c *gin.Context) {
+func (h *Handler) DeleteUser(c *gin.Context) {
 	// Логика для удаления пользователя
 	// Получаем ID пользователя из контекста
 	userID, exists := c.Get("userID")
@@ -147,10 +270,16 @@ func DeleteUser(c *gin.Context) {
 		c.JSON(401, gin.H{"error": "User not authenticated"})
 		return
 	}
+	// Режим удаления: anonymize оставляет посты и комментарии под автором-заглушкой, erase удаляет их
+	mode := c.DefaultQuery("mode", models.DeletionErase)
+	if !jobs.ValidDeletionMode(mode) {
+		c.JSON(400, gin.H{"error": "Invalid mode, expected anonymize or erase"})
+		return
+	}
 	// Ищем пользователя в базе данных по ID
-	var user models.User
-	if err := db.DB.Where("id = ?", userID).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	user, err := h.Users.GetByID(userID.(int))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			// Пользователь с таким id не найден
 			c.JSON(404, gin.H{"error": "User not found"})
 			return
@@ -159,31 +288,73 @@ func DeleteUser(c *gin.Context) {
 			return
 		}
 	}
-	// Удаляем пользователя
-	if err := db.DB.Delete(&user).Error; err != nil {
+	// Помечаем пользователя удалённым и планируем задачу удаления: до окончания срока восстановления
+	// аккаунт можно вернуть, потом задача обработает его посты, комментарии и лайки и удалит аккаунт
+	deletedAt := time.Now()
+	var deletion *models.AccountDeletion
+	err = h.Transaction(func(tx repository.Repositories) error {
+		if err := tx.Users.SoftDelete(user.ID, deletedAt); err != nil {
+			return err
+		}
+		deletion, err = jobs.ScheduleAccountDeletion(tx, user.ID, mode, deletedAt)
+		return err
+	})
+	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to delete user"})
 		return
 	}
+	// Токены отзываем только после удаления: если транзакция не прошла, пользователь остаётся в системе
+	if err := auth.RevokeAllUserTokens(user.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
 
-	c.JSON(200, gin.H{"message": "User deleted successfully"})
+	c.JSON(200, gin.H{
+		"message":       "User scheduled for deletion, the account can be restored until restore_until",
+		"restore_until": formatTime(deletedAt.Add(jobs.RestoreWindow())),
+		"deletion":      accountDeletionResponse(deletion),
+	})
 }
 
+// hashPassword хеширует пароль текущим алгоритмом и параметрами из auth.Passwords
 func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	return auth.Passwords.Hash(password)
+}
+
+// verifyPassword сверяет пароль с хешем пользователя. Нераспознанный хеш считается несовпадением
+func verifyPassword(user models.User, password string) bool {
+	ok, _, err := auth.Passwords.Verify(user.Password, password)
+	if err != nil {
+		log.Printf("Failed to verify password of user %d: %v", user.ID, err)
+	}
+	return ok
+}
+
+// formatTime выводит время в формате RFC 3339 в UTC
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatOptionalTime выводит время в формате RFC 3339 или null, если его нет
+func formatOptionalTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return formatTime(*t)
 }
 
 // Блок работы с постами
-func CreatePost(c *gin.Context) {
-	var post models.Post
-	// Привязываем JSON-данные из тела запроса к переменной post
-	if err := c.ShouldBindJSON(&post); err != nil {
+func (h *Handler) CreatePost(c *gin.Context) {
+	// Принимаем только текст: время и счётчики поста задаёт сервер
+	var request struct {
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	post := models.Post{Content: request.Content}
 
-	// Устанавливем текущие дата/время для поста
-	post.Date = int(time.Now().Unix())
 	// Устанавливаем userID из контекста
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -193,28 +364,34 @@ func CreatePost(c *gin.Context) {
 	post.UserID = userID.(int)
 
 	// Сохраняем пост в базе данных
-	if err := db.DB.Create(&post).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Error creating post"})
+	if err := h.Posts.Create(&post); err != nil {
+		if !respondConstraintError(c, err) {
+			c.JSON(500, gin.H{"error": "Error creating post"})
+		}
 		return
 	}
 
 	// Возвращаем успешный ответ с данными о созданном посте
+	setETag(c, post.Version)
 	c.JSON(201, gin.H{
-		"id":      post.ID,
-		"user_id": post.UserID,
-		"date":    post.Date,
-		"content": post.Content,
+		"id":         post.ID,
+		"user_id":    post.UserID,
+		"date":       post.LegacyDate(),
+		"created_at": formatTime(post.CreatedAt),
+		"updated_at": formatTime(post.UpdatedAt),
+		"edited_at":  formatOptionalTime(post.EditedAt),
+		"content":    post.Content,
 	})
 }
 
-func DeletePost(c *gin.Context) {
+func (h *Handler) DeletePost(c *gin.Context) {
 	// Получаем ID поста и ID пользователя из контекста
 	postID, _ := c.Get("postID")
 	userID, _ := c.Get("userID")
 	// Ищем пост в базе данных по ID
-	var post models.Post
-	if err := db.DB.Where("id = ?", postID).First(&post).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	post, err := h.Posts.GetByID(postID.(int))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			// Пост с таким id не найден
 			c.JSON(404, gin.H{"error": "Post not found"})
 			return
@@ -223,35 +400,55 @@ func DeletePost(c *gin.Context) {
 			return
 		}
 	}
-	// Проверяем автора и удаляем пост
-	if userID == post.UserID {
-		// Удаляем пост
-		if err := db.DB.Delete(&post).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to delete post"})
-			return
-		}
+	// Удалить пост может его автор, а также модератор или администратор
+	moderated := userID != post.UserID
+	if moderated && !canModerate(c) {
+		c.JSON(403, gin.H{"error": "You must be the author of the post"})
+		return
+	}
 
-		// Удаляем все комментарии, связанные с постом
-		if err := db.DB.Where("post_id = ?", postID).Delete(&models.Comment{}).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to delete comments"})
-			return
+	deletedAt := time.Now()
+	err = h.Transaction(func(tx repository.Repositories) error {
+		// Блокируем пост: параллельные лайки и новые комментарии дождутся удаления и получат 404
+		if _, err := tx.Posts.LockByID(post.ID); err != nil {
+			return err
+		}
+		// Пост только помечается удалённым, вместе с ним скрываются его комментарии
+		if err := tx.Posts.SoftDelete(post.ID, userID.(int), deletedAt); err != nil {
+			return errors.New("Failed to delete post")
 		}
 
-		c.JSON(200, gin.H{"message": "Post and related comments deleted successfully"})
-	} else {
-		c.JSON(403, gin.H{"error": "You must be the author of the post"})
+		// Действие над чужим постом попадает в журнал администратора
+		if moderated {
+			return recordAdminAction(tx, c, "post.delete", "post", post.ID, post.Content)
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		// Удалён параллельным запросом
+		c.JSON(404, gin.H{"error": "Post not found"})
+		return
 	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message":       "Post and its comments scheduled for deletion, they can be restored until restore_until",
+		"restore_until": formatTime(deletedAt.Add(jobs.RestoreWindow())),
+	})
 }
 
-func UpdatePost(c *gin.Context) {
+func (h *Handler) UpdatePost(c *gin.Context) {
 	// Получаем ID поста и ID пользователя из контекста
 	postID, _ := c.Get("postID")
 	userID, _ := c.Get("userID")
 
 	// Ищем пост в базе данных по ID
-	var post models.Post
-	if err := db.DB.Where("id = ?", postID).First(&post).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	post, err := h.Posts.GetByID(postID.(int))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(404, gin.H{"error": "Post not found"})
 			return
 		}
@@ -259,11 +456,16 @@ func UpdatePost(c *gin.Context) {
 		return
 	}
 
-	// Проверяем, что пользователь является автором поста
-	if userID != post.UserID {
+	// Проверяем, что пользователь является автором поста, модератором или администратором
+	moderated := userID != post.UserID
+	if moderated && !canModerate(c) {
 		c.JSON(403, gin.H{"error": "You must be the author of the post to update it"})
 		return
 	}
+	// Клиент, передавший ETag, правит ту версию, которую видел
+	if !checkIfMatch(c, post.Version) {
+		return
+	}
 
 	// Привязываем JSON с изменениями к структуре
 	var updateData struct {
@@ -280,28 +482,52 @@ func UpdatePost(c *gin.Context) {
 		return
 	}
 
-	// Обновляем контент и дату поста
-	updates := map[string]interface{}{
-		"content": updateData.Content,
-		"date":    int(time.Now().Unix()),
+	// Обновляем контент и время правки поста
+	editedAt := time.Now()
+	previousContent := post.Content
+	post.Content = updateData.Content
+	post.EditedAt = &editedAt
+	err = h.Transaction(func(tx repository.Repositories) error {
+		if err := tx.Posts.Update(post, repository.FieldContent, repository.FieldEditedAt); err != nil {
+			return err
+		}
+		// Правка чужого поста попадает в журнал администратора
+		if moderated {
+			return recordAdminAction(tx, c, "post.update", "post", post.ID, previousContent)
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrVersionConflict) {
+		// Пост изменили между чтением и сохранением: отвечаем так же, как на устаревший If-Match
+		current, err := h.Posts.GetByID(post.ID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Post not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Database error"})
+			return
+		}
+		respondPreconditionFailed(c, current.Version)
+		return
 	}
-	if err := db.DB.Model(&post).Updates(updates).Error; err != nil {
+	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to update post"})
 		return
 	}
 
-	// Обновляем значение `post` для возврата обновленных данных
-	post.Content = updateData.Content
-	post.Date = updates["date"].(int)
-
 	// Возвращаем успешный ответ с обновленными данными поста
+	setETag(c, post.Version)
 	c.JSON(200, gin.H{
-		"content": post.Content,
-		"date":    post.Date,
+		"content":    post.Content,
+		"date":       post.LegacyDate(),
+		"created_at": formatTime(post.CreatedAt),
+		"updated_at": formatTime(post.UpdatedAt),
+		"edited_at":  formatOptionalTime(post.EditedAt),
 	})
 }
 
-func GetPost(c *gin.Context) {
+func (h *Handler) GetPost(c *gin.Context) {
 	// Получаем ID пользователя из параметров URL
 	postID, err := strconv.Atoi(c.Param("postID"))
 	if err != nil {
@@ -309,9 +535,9 @@ func GetPost(c *gin.Context) {
 		return
 	}
 	// Проверяем наличие пользователя с таким ID
-	var existingPost models.Post
-	if err := db.DB.Where("id = ?", postID).First(&existingPost).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	existingPost, err := h.Posts.GetByID(postID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(404, gin.H{"error": "Post not found"})
 			return
 		} else {
@@ -319,24 +545,30 @@ func GetPost(c *gin.Context) {
 			return
 		}
 	}
+	setETag(c, existingPost.Version)
 	c.JSON(200, gin.H{
-		"id":      existingPost.ID,
-		"content": existingPost.Content,
-		"userID":  existingPost.UserID,
+		"id":         existingPost.ID,
+		"content":    existingPost.Content,
+		"userID":     existingPost.UserID,
+		"date":       existingPost.LegacyDate(),
+		"created_at": formatTime(existingPost.CreatedAt),
+		"updated_at": formatTime(existingPost.UpdatedAt),
+		"edited_at":  formatOptionalTime(existingPost.EditedAt),
 	})
 }
 
 // Блок работы с комментариями
-func CreateComment(c *gin.Context) {
-	var comment models.Comment
-	// Привязываем JSON-данные из тела запроса к переменной comment
-	if err := c.ShouldBindJSON(&comment); err != nil {
+func (h *Handler) CreateComment(c *gin.Context) {
+	// Принимаем только текст: время и счётчики комментария задаёт сервер
+	var request struct {
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	comment := models.Comment{Content: request.Content}
 
-	// Устанавливем текущие дата/время для комментария
-	comment.Date = int(time.Now().Unix())
 	// Устанавливаем userID и postID из контекста
 	postID, postExists := c.Get("postID")
 	userID, userExists := c.Get("userID")
@@ -346,35 +578,57 @@ func CreateComment(c *gin.Context) {
 	}
 	comment.UserID = userID.(int)
 	comment.PostID = postID.(int)
-	// Сохраняем комментарий в базе данных
-	if err := db.DB.Create(&comment).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Error creating comment"})
+
+	// Комментарий и счётчик поста меняются в одной транзакции. Строка поста блокируется,
+	// чтобы пост не удалили между проверкой и вставкой комментария
+	err := h.Transaction(func(tx repository.Repositories) error {
+		if _, err := tx.Posts.LockByID(comment.PostID); err != nil {
+			return err
+		}
+		// Сохраняем комментарий в базе данных
+		if err := tx.Comments.Create(&comment); err != nil {
+			return constraintOr(err, "Error creating comment")
+		}
+		// Увеличиваем количество комментариев в посте на 1
+		if err := tx.Posts.AddComments(comment.PostID, 1); err != nil {
+			return errors.New("Failed to update post comments count")
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(404, gin.H{"error": "Post not found"})
+		return
+	}
+	if respondConstraintError(c, err) {
 		return
 	}
-	// Увеличиваем количество комментариев в посте на 1
-	if err := db.DB.Model(&models.Post{}).Where("id = ?", comment.PostID).UpdateColumn("comments", gorm.Expr("comments + ?", 1)).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update post comments count"})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Возвращаем успешный ответ с данными о созданном посте
+	setETag(c, comment.Version)
 	c.JSON(201, gin.H{
-		"id":      comment.ID,
-		"user_id": comment.UserID,
-		"post_id": comment.PostID,
-		"date":    comment.Date,
-		"content": comment.Content,
+		"id":         comment.ID,
+		"user_id":    comment.UserID,
+		"post_id":    comment.PostID,
+		"date":       comment.LegacyDate(),
+		"created_at": formatTime(comment.CreatedAt),
+		"updated_at": formatTime(comment.UpdatedAt),
+		"edited_at":  formatOptionalTime(comment.EditedAt),
+		"content":    comment.Content,
 	})
 }
 
-func DeleteComment(c *gin.Context) {
+func (h *Handler) DeleteComment(c *gin.Context) {
 	commentID, _ := c.Get("commentID")
 	userID, _ := c.Get("userID")
 
 	// Ищем комментарий в базе данных по ID
-	var comment models.Comment
-	if err := db.DB.Where("id = ?", commentID).First(&comment).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	comment, err := h.Comments.GetByID(commentID.(int))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			//
 			c.JSON(404, gin.H{"error": "Comment not found"})
 			return
@@ -383,33 +637,57 @@ func DeleteComment(c *gin.Context) {
 			return
 		}
 	}
-	// Проверяем автора и удаляем комментарий
-	if userID == comment.UserID {
-		if err := db.DB.Delete(&comment).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to delete comment"})
-			return
-		}
-		c.JSON(200, gin.H{"message": "Comment deleted successfully"})
-	} else {
+	// Удалить комментарий может его автор, а также модератор или администратор
+	moderated := userID != comment.UserID
+	if moderated && !canModerate(c) {
 		c.JSON(403, gin.H{"error": "You must be author of the comment"})
 		return
 	}
-	// Уменьшаем количество комментариев в посте на 1
-	if err := db.DB.Model(&models.Post{}).Where("id = ?", comment.PostID).UpdateColumn("comments", gorm.Expr("comments - ?", 1)).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update post comments count"})
+
+	deletedAt := time.Now()
+	err = h.Transaction(func(tx repository.Repositories) error {
+		// Блокируем комментарий: параллельные лайки дождутся удаления и получат 404
+		if _, err := tx.Comments.LockByID(comment.ID); err != nil {
+			return err
+		}
+		if err := tx.Comments.SoftDelete(comment.ID, userID.(int), deletedAt); err != nil {
+			return errors.New("Failed to delete comment")
+		}
+		// Уменьшаем количество комментариев в посте на 1
+		if err := tx.Posts.AddComments(comment.PostID, -1); err != nil {
+			return errors.New("Failed to update post comments count")
+		}
+		// Действие над чужим комментарием попадает в журнал администратора
+		if moderated {
+			return recordAdminAction(tx, c, "comment.delete", "comment", comment.ID, comment.Content)
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		// Удалён параллельным запросом
+		c.JSON(404, gin.H{"error": "Comment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+
+	c.JSON(200, gin.H{
+		"message":       "Comment scheduled for deletion, it can be restored until restore_until",
+		"restore_until": formatTime(deletedAt.Add(jobs.RestoreWindow())),
+	})
 }
 
-func UpdateComment(c *gin.Context) {
+func (h *Handler) UpdateComment(c *gin.Context) {
 	// Получаем ID комментария и ID пользователя из контекста
 	commentID, _ := c.Get("commentID")
 	userID, _ := c.Get("userID")
 
 	// Ищем комментарий в базе данных по ID
-	var comment models.Post
-	if err := db.DB.Where("id = ?", commentID).First(&comment).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	comment, err := h.Comments.GetByID(commentID.(int))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(404, gin.H{"error": "Comment not found"})
 			return
 		}
@@ -417,11 +695,16 @@ func UpdateComment(c *gin.Context) {
 		return
 	}
 
-	// Проверяем, что пользователь является автором комментария
-	if userID != comment.UserID {
+	// Проверяем, что пользователь является автором комментария, модератором или администратором
+	moderated := userID != comment.UserID
+	if moderated && !canModerate(c) {
 		c.JSON(403, gin.H{"error": "You must be the author of the comment to update it"})
 		return
 	}
+	// Клиент, передавший ETag, правит ту версию, которую видел
+	if !checkIfMatch(c, comment.Version) {
+		return
+	}
 
 	// Привязываем JSON с изменениями к структуре
 	var updateData struct {
@@ -438,28 +721,51 @@ func UpdateComment(c *gin.Context) {
 		return
 	}
 
-	// Обновляем контент и дату поста
-	updates := map[string]interface{}{
-		"content": updateData.Content,
-		"date":    int(time.Now().Unix()),
+	// Обновляем контент и время правки комментария
+	editedAt := time.Now()
+	previousContent := comment.Content
+	comment.Content = updateData.Content
+	comment.EditedAt = &editedAt
+	err = h.Transaction(func(tx repository.Repositories) error {
+		if err := tx.Comments.Update(comment, repository.FieldContent, repository.FieldEditedAt); err != nil {
+			return err
+		}
+		// Правка чужого комментария попадает в журнал администратора
+		if moderated {
+			return recordAdminAction(tx, c, "comment.update", "comment", comment.ID, previousContent)
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrVersionConflict) {
+		current, err := h.Comments.GetByID(comment.ID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Comment not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Database error"})
+			return
+		}
+		respondPreconditionFailed(c, current.Version)
+		return
 	}
-	if err := db.DB.Model(&comment).Updates(updates).Error; err != nil {
+	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to update comment"})
 		return
 	}
 
-	// Обновляем значение `comment` для возврата обновленных данных
-	comment.Content = updateData.Content
-	comment.Date = updates["date"].(int)
-
 	// Возвращаем успешный ответ с обновленными данными поста
+	setETag(c, comment.Version)
 	c.JSON(200, gin.H{
-		"content": comment.Content,
-		"date":    comment.Date,
+		"content":    comment.Content,
+		"date":       comment.LegacyDate(),
+		"created_at": formatTime(comment.CreatedAt),
+		"updated_at": formatTime(comment.UpdatedAt),
+		"edited_at":  formatOptionalTime(comment.EditedAt),
 	})
 }
 
-func GetComment(c *gin.Context) {
+func (h *Handler) GetComment(c *gin.Context) {
 	// Получаем ID пользователя из параметров URL
 	commentID, err := strconv.Atoi(c.Param("commentID"))
 	if err != nil {
@@ -467,9 +773,9 @@ func GetComment(c *gin.Context) {
 		return
 	}
 	// Проверяем наличие комментария с таким ID
-	var existingComment models.Comment
-	if err := db.DB.Where("id = ?", commentID).First(&existingComment).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	existingComment, err := h.Comments.GetByID(commentID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(404, gin.H{"error": "Post not found"})
 			return
 		} else {
@@ -477,92 +783,139 @@ func GetComment(c *gin.Context) {
 			return
 		}
 	}
+	setETag(c, existingComment.Version)
 	c.JSON(200, gin.H{
-		"id":      existingComment.ID,
-		"userID":  existingComment.UserID,
-		"postID":  existingComment.PostID,
-		"content": existingComment.Content,
+		"id":         existingComment.ID,
+		"userID":     existingComment.UserID,
+		"postID":     existingComment.PostID,
+		"content":    existingComment.Content,
+		"date":       existingComment.LegacyDate(),
+		"created_at": formatTime(existingComment.CreatedAt),
+		"updated_at": formatTime(existingComment.UpdatedAt),
+		"edited_at":  formatOptionalTime(existingComment.EditedAt),
 	})
 }
 
 // Блок работы с лайками
-func LikePost(c *gin.Context) {
-	userID, _ := c.Get("userID")
-	postID, _ := c.Get("postID")
-
-	// Проверка, ставил ли уже лайк этот пользователь, и удаление лайка, если он стоит
-	var like models.Like
-	if err := db.DB.Where("user_id = ? AND post_id = ?", userID, postID).First(&like).Error; err == nil {
-		// Лайк найден, значит удаляем его
-		if err := db.DB.Delete(&like).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to remove like"})
-			return
+func (h *Handler) LikePost(c *gin.Context) {
+	userID := c.GetInt("userID")
+	postID := c.GetInt("postID")
+
+	// Лайк и счётчик меняются в одной транзакции. Строка поста блокируется до её конца,
+	// поэтому два одновременных запроса не вставят два лайка и не собьют счётчик
+	removed := false
+	err := h.Transaction(func(tx repository.Repositories) error {
+		if _, err := tx.Posts.LockByID(postID); err != nil {
+			return err
 		}
 
-		// Уменьшаем количество лайков у поста
-		if err := db.DB.Model(&models.Post{}).Where("id = ?", postID).Update("likes", gorm.Expr("likes - ?", 1)).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to update like count"})
-			return
+		// Проверка, ставил ли уже лайк этот пользователь, и удаление лайка, если он стоит
+		like, err := tx.Likes.FindPostLike(userID, postID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return errors.New("Database error")
+		}
+		if err == nil {
+			removed = true
+			if err := tx.Likes.Delete(like.ID); err != nil {
+				return errors.New("Failed to remove like")
+			}
+			// Уменьшаем количество лайков у поста
+			if err := tx.Posts.AddLikes(postID, -1); err != nil {
+				return errors.New("Failed to update like count")
+			}
+			return nil
 		}
 
-		c.JSON(200, gin.H{"message": "Like removed successfully"})
+		// Добавляем лайк, так как его еще нет
+		newLike := models.Like{
+			UserID: userID,
+			PostID: &postID,
+		}
+		if err := tx.Likes.Create(&newLike); err != nil {
+			return constraintOr(err, "Failed to add like")
+		}
+		// Увеличиваем счётчик лайков у поста
+		if err := tx.Posts.AddLikes(postID, 1); err != nil {
+			return errors.New("Failed to update like count")
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(404, gin.H{"error": "Post not found"})
 		return
 	}
-
-	// Добавляем лайк, так как его еще нет
-	newLike := models.Like{
-		UserID: userID.(int),
-		PostID: postID.(*int),
+	if respondConstraintError(c, err) {
+		return
 	}
-	if err := db.DB.Create(&newLike).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to add like"})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Увеличиваем счётчик лайков у поста
-	if err := db.DB.Model(&models.Post{}).Where("id = ?", postID).Update("likes", gorm.Expr("likes + ?", 1)).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update like count"})
+	if removed {
+		c.JSON(200, gin.H{"message": "Like removed successfully"})
 		return
 	}
-
 	c.JSON(200, gin.H{"message": "Post liked successfully"})
 }
 
-func LikeComment(c *gin.Context) {
-	userID, _ := c.Get("userID")
-	commentID, _ := c.Get("commentID")
+func (h *Handler) LikeComment(c *gin.Context) {
+	userID := c.GetInt("userID")
+	commentID := c.GetInt("commentID")
 
-	// Проверка, ставил ли уже лайк этот пользователь и удалить в случае, если он стоит
-	var like models.Like
-	if err := db.DB.Where("user_id = ? AND comment_id = ?", userID, commentID).First(&like).Error; err == nil { // Здесь исправлено
-		if err := db.DB.Delete(&like).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to remove like"})
-			return
+	// Как и в LikePost, строка комментария блокируется до конца транзакции
+	removed := false
+	err := h.Transaction(func(tx repository.Repositories) error {
+		if _, err := tx.Comments.LockByID(commentID); err != nil {
+			return err
 		}
-		// Уменьшаем количество лайков в комментарии
-		if err := db.DB.Model(&models.Comment{}).Where("id = ?", commentID).Update("likes", gorm.Expr("likes - ?", 1)).Error; err != nil { // Здесь исправлено
-			c.JSON(500, gin.H{"error": "Failed to update like count"})
-			return
+
+		// Проверка, ставил ли уже лайк этот пользователь и удалить в случае, если он стоит
+		like, err := tx.Likes.FindCommentLike(userID, commentID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return errors.New("Database error")
 		}
-		c.JSON(200, gin.H{"message": "Like removed successfully"})
+		if err == nil {
+			removed = true
+			if err := tx.Likes.Delete(like.ID); err != nil {
+				return errors.New("Failed to remove like")
+			}
+			// Уменьшаем количество лайков в комментарии
+			if err := tx.Comments.AddLikes(commentID, -1); err != nil {
+				return errors.New("Failed to update like count")
+			}
+			return nil
+		}
+
+		// Добавляем лайк
+		newLike := models.Like{
+			UserID:    userID,
+			CommentID: &commentID,
+		}
+		if err := tx.Likes.Create(&newLike); err != nil {
+			return constraintOr(err, "Failed to add like")
+		}
+		// Увеличиваем счётчик лайков комментария
+		if err := tx.Comments.AddLikes(commentID, 1); err != nil {
+			return errors.New("Failed to update like count")
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(404, gin.H{"error": "Comment not found"})
 		return
 	}
-
-	// Добавляем лайк
-	newLike := models.Like{
-		UserID:    userID.(int),
-		CommentID: commentID.(*int),
+	if respondConstraintError(c, err) {
+		return
 	}
-	if err := db.DB.Create(&newLike).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to add like"})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Увеличиваем счётчик лайков комментария
-	if err := db.DB.Model(&models.Comment{}).Where("id = ?", commentID).Update("likes", gorm.Expr("likes + ?", 1)).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update like count"})
+	if removed {
+		c.JSON(200, gin.H{"message": "Like removed successfully"})
 		return
 	}
-
 	c.JSON(200, gin.H{"message": "Comment liked successfully"})
 }
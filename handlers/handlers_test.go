@@ -0,0 +1,297 @@
+package handlers_test
+
+import (
+	"apiForSN/auth"
+	"apiForSN/handlers"
+	"apiForSN/mailer"
+	"apiForSN/middleware"
+	"apiForSN/models"
+	"apiForSN/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// captureMailer запоминает отправленные письма вместо отправки
+type captureMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *captureMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// verificationLink возвращает ссылку из последнего письма на адрес to
+func (m *captureMailer) verificationLink(t *testing.T, to string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To != to {
+			continue
+		}
+		for _, field := range strings.Fields(m.messages[i].Body) {
+			if link, err := url.Parse(field); err == nil && link.Path == "/auth/verify" {
+				return link.RequestURI()
+			}
+		}
+	}
+	t.Fatalf("no verification email sent to %s", to)
+	return ""
+}
+
+// testServer собирает маршруты из main поверх хранилища в памяти
+type testServer struct {
+	t      *testing.T
+	repos  repository.Repositories
+	mail   *captureMailer
+	router *gin.Engine
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if err := auth.GenerateEphemeralKey(); err != nil {
+		t.Fatal(err)
+	}
+	auth.Passwords.BcryptCost = bcrypt.MinCost
+	// Счётчики попыток общие для пакета, поэтому у каждого теста свои
+	auth.LoginLockout = auth.NewLockout(auth.NewMemoryAttemptStore(), auth.DefaultAccountPolicy, auth.DefaultIPPolicy)
+	auth.PasswordResetLockout = auth.NewLockout(auth.NewMemoryAttemptStore(), auth.PasswordResetAccountPolicy, auth.PasswordResetIPPolicy)
+
+	repos := repository.NewMemory()
+	auth.UseStorage(repos)
+	mail := &captureMailer{}
+	mailer.Default = mail
+
+	h := handlers.New(repos)
+	router := gin.New()
+	router.POST("/auth/signup", h.CreateUser)
+	router.GET("/auth/verify", h.VerifyEmail)
+	router.POST("/auth/login", h.Login)
+	router.POST("/auth/login/mfa", h.LoginMFA)
+	router.POST("/auth/refresh", h.Refresh)
+	router.POST("/auth/logout", middleware.AuthMiddleware(), h.Logout)
+	router.POST("/auth/logout-all", middleware.AuthMiddleware(), h.LogoutAll)
+	api := router.Group("/api", middleware.AuthMiddleware())
+	api.GET("/user", h.GetUser)
+	api.POST("/user/2fa/enroll", h.EnrollTOTP)
+	api.POST("/user/2fa/enable", h.EnableTOTP)
+	posts := api.Group("/posts")
+	posts.POST("/", middleware.RequireVerifiedEmail(h.Users), h.CreatePost)
+	posts.Use(middleware.PostIDMiddleware())
+	posts.GET("/:postID", h.GetPost)
+	posts.PUT("/:postID", h.UpdatePost)
+	posts.DELETE("/:postID", h.DeletePost)
+	posts.POST("/:postID/restore", h.RestorePost)
+	posts.POST("/:postID/like", h.LikePost)
+	admin := api.Group("/admin", middleware.RequireRole(auth.RoleAdmin))
+	admin.POST("/users/:id/unlock", h.UnlockUser)
+
+	return &testServer{t: t, repos: repos, mail: mail, router: router}
+}
+
+// do выполняет запрос и возвращает код ответа и разобранное тело
+func (s *testServer) do(method, path, token, body string) (int, map[string]interface{}) {
+	s.t.Helper()
+	code, _, response := s.doWithHeaders(method, path, token, body, nil)
+	return code, response
+}
+
+// doWithHeaders выполняет запрос с дополнительными заголовками и возвращает также заголовки ответа
+func (s *testServer) doWithHeaders(method, path, token, body string, headers map[string]string) (int, http.Header, map[string]interface{}) {
+	s.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		s.t.Fatalf("%s %s: invalid JSON %q", method, path, rec.Body.String())
+	}
+	return rec.Code, rec.Header(), response
+}
+
+// signup регистрирует пользователя, подтверждает email и возвращает токен
+func (s *testServer) signup(nickname, email string) string {
+	s.t.Helper()
+	code, response := s.do("POST", "/auth/signup", "",
+		`{"nickname":"`+nickname+`","email":"`+email+`","password":"Secret123!"}`)
+	if code != 201 {
+		s.t.Fatalf("signup %s: status %d, body %v", nickname, code, response)
+	}
+	if code, response := s.do("GET", s.mail.verificationLink(s.t, email), "", ""); code != 200 {
+		s.t.Fatalf("verify %s: status %d, body %v", email, code, response)
+	}
+	token, _ := response["token"].(string)
+	return token
+}
+
+// login входит по паролю из signup и возвращает ответ на вход
+func (s *testServer) login(email string) map[string]interface{} {
+	s.t.Helper()
+	code, response := s.do("POST", "/auth/login", "", `{"email":"`+email+`","password":"Secret123!"}`)
+	if code != 200 {
+		s.t.Fatalf("login %s: status %d, body %v", email, code, response)
+	}
+	return response
+}
+
+// createPost создаёт пост от имени владельца token и возвращает путь к нему
+func (s *testServer) createPost(token, content string) string {
+	s.t.Helper()
+	code, response := s.do("POST", "/api/posts/", token, `{"content":"`+content+`"}`)
+	if code != 201 {
+		s.t.Fatalf("create post: status %d, body %v", code, response)
+	}
+	return fmt.Sprintf("/api/posts/%v", response["id"])
+}
+
+func TestSignupConflicts(t *testing.T) {
+	s := newTestServer(t)
+	s.signup("alice", "alice@example.com")
+
+	tests := []struct {
+		name, body string
+	}{
+		{"duplicate email", `{"nickname":"alice2","email":"Alice@Example.com","password":"Secret123!"}`},
+		{"duplicate nickname", `{"nickname":"ALICE","email":"other@example.com","password":"Secret123!"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, response := s.do("POST", "/auth/signup", "", tt.body); code != 409 {
+				t.Errorf("status %d, want 409, body %v", code, response)
+			}
+		})
+	}
+}
+
+func TestLikeIsUnique(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice", "alice@example.com")
+	bob := s.signup("bob", "bob@example.com")
+
+	if code, response := s.do("POST", "/api/posts/", alice, `{"content":"hello"}`); code != 201 {
+		t.Fatalf("create post: status %d, body %v", code, response)
+	}
+
+	// Повторный лайк через API снимает первый, счётчик возвращается к нулю
+	for _, want := range []int{1, 0} {
+		if code, response := s.do("POST", "/api/posts/1/like", bob, ""); code != 200 {
+			t.Fatalf("like: status %d, body %v", code, response)
+		}
+		post, err := s.repos.Posts.GetByID(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if post.Likes != want {
+			t.Errorf("likes = %d, want %d", post.Likes, want)
+		}
+	}
+
+	// Второй лайк в обход обработчика отклоняет само хранилище, как уникальный индекс в базе
+	postID := 1
+	user, err := s.repos.Users.GetByEmail("bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repos.Likes.Create(&models.Like{UserID: user.ID, PostID: &postID}); err != nil {
+		t.Fatal(err)
+	}
+	err = s.repos.Likes.Create(&models.Like{UserID: user.ID, PostID: &postID})
+	if !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("duplicate like: err = %v, want ErrDuplicate", err)
+	}
+}
+
+func TestUpdatePostOwnership(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice", "alice@example.com")
+	bob := s.signup("bob", "bob@example.com")
+
+	if code, response := s.do("POST", "/api/posts/", alice, `{"content":"hello"}`); code != 201 {
+		t.Fatalf("create post: status %d, body %v", code, response)
+	}
+	if code, response := s.do("PUT", "/api/posts/1", bob, `{"content":"mine now"}`); code != http.StatusForbidden {
+		t.Errorf("update by another user: status %d, want 403, body %v", code, response)
+	}
+	if code, response := s.do("PUT", "/api/posts/1", alice, `{"content":"edited"}`); code != 200 {
+		t.Errorf("update by author: status %d, want 200, body %v", code, response)
+	}
+}
+
+func TestUpdateMissingPostIsVersionConflict(t *testing.T) {
+	repos := repository.NewMemory()
+	err := repos.Posts.Update(&models.Post{ID: 42, Version: 1, Content: "x"}, repository.FieldContent)
+	if !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("err = %v, want ErrVersionConflict as in the GORM repository", err)
+	}
+}
+
+func TestUpdatePostWithStaleETag(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice", "alice@example.com")
+	post := s.createPost(alice, "v1")
+
+	code, headers, response := s.doWithHeaders("PUT", post, alice, `{"content":"v2"}`, map[string]string{"If-Match": `"1"`})
+	if code != 200 || headers.Get("ETag") != `"2"` {
+		t.Fatalf("update with current ETag: status %d, ETag %q, body %v", code, headers.Get("ETag"), response)
+	}
+
+	// Правка по устаревшей версии отклоняется, а ответ сообщает текущую
+	code, headers, response = s.doWithHeaders("PUT", post, alice, `{"content":"v3"}`, map[string]string{"If-Match": `"1"`})
+	if code != http.StatusPreconditionFailed || headers.Get("ETag") != `"2"` {
+		t.Errorf("update with stale ETag: status %d, ETag %q, body %v", code, headers.Get("ETag"), response)
+	}
+	if _, response := s.do("GET", post, alice, ""); response["content"] != "v2" {
+		t.Errorf("content = %v, want v2", response["content"])
+	}
+}
+
+func TestRestorePostWithinWindow(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice", "alice@example.com")
+	post := s.createPost(alice, "hello")
+
+	if code, response := s.do("DELETE", post, alice, ""); code != 200 || response["restore_until"] == nil {
+		t.Fatalf("delete: status %d, body %v", code, response)
+	}
+	if code, _ := s.do("GET", post, alice, ""); code != 404 {
+		t.Errorf("deleted post: status %d, want 404", code)
+	}
+	if code, response := s.do("POST", post+"/restore", alice, ""); code != 200 {
+		t.Fatalf("restore: status %d, body %v", code, response)
+	}
+	if code, _ := s.do("GET", post, alice, ""); code != 200 {
+		t.Errorf("restored post: status %d, want 200", code)
+	}
+
+	// После окончания срока восстановления пост вернуть нельзя
+	if code, response := s.do("DELETE", post, alice, ""); code != 200 {
+		t.Fatalf("delete: status %d, body %v", code, response)
+	}
+	t.Setenv("RESTORE_WINDOW", "1ns")
+	if code, _ := s.do("POST", post+"/restore", alice, ""); code != http.StatusGone {
+		t.Errorf("restore after the window: status %d, want 410", code)
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"apiForSN/auth"
+	"apiForSN/mailer"
+	"apiForSN/repository"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Срок действия ссылки для сброса пароля
+const passwordResetTTL = time.Hour
+
+// errHashPassword возвращается из транзакции сброса пароля, если новый пароль не удалось захешировать
+var errHashPassword = errors.New("failed to hash password")
+
+// Блок восстановления пароля
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || strings.TrimSpace(request.Email) == "" {
+		c.JSON(400, gin.H{"error": "Email is required"})
+		return
+	}
+
+	// Запросы ограничиваются по введённому адресу и IP, а не по существующим аккаунтам,
+	// поэтому отказ ничего не говорит о том, зарегистрирован ли такой email
+	email := strings.ToLower(strings.TrimSpace(request.Email))
+	wait, err := auth.PasswordResetLockout.Check(email, c.ClientIP())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to check password reset requests"})
+		return
+	}
+	if wait > 0 {
+		respondTooManyRequests(c, wait, "Too many password reset requests, try again later")
+		return
+	}
+	if err := auth.PasswordResetLockout.RecordFailure(email, c.ClientIP()); err != nil {
+		log.Printf("Failed to record password reset request: %v", err)
+	}
+
+	// Поиск пользователя и отправка письма выполняются в фоне, поэтому ни ответ, ни время ответа
+	// не зависят от того, зарегистрирован ли такой email
+	go h.sendPasswordResetEmail(email)
+
+	c.JSON(202, gin.H{"message": "If an account with this email exists, a password reset email has been sent"})
+}
+
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.Token == "" {
+		c.JSON(400, gin.H{"error": "Token and password are required"})
+		return
+	}
+	if err := validatePassword(request.Password); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Погашаем токен и меняем пароль в одной транзакции. Пароль хешируется только после проверки токена,
+	// чтобы запросы с неверным токеном не тратили время на хеширование
+	userID, err := auth.ConsumeOneTimeToken(request.Token, auth.PurposePasswordReset, func(tx repository.Repositories, userID int) error {
+		hashedPassword, err := hashPassword(request.Password)
+		if err != nil {
+			return errHashPassword
+		}
+		user, err := tx.Users.GetByID(userID)
+		if err != nil {
+			return err
+		}
+		// Токен из письма подтверждает владение адресом
+		user.Password = hashedPassword
+		user.EmailVerified = true
+		return tx.Users.Update(user, repository.FieldPassword, repository.FieldEmailVerified)
+	})
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidOneTimeToken) {
+			c.JSON(400, gin.H{"error": "Invalid or expired reset token"})
+			return
+		}
+		if errors.Is(err, errHashPassword) {
+			c.JSON(500, gin.H{"error": "Error hashing password"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	// Все ранее выданные токены пользователя перестают действовать
+	if err := auth.RevokeAllUserTokens(userID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke existing tokens"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Password has been reset successfully"})
+}
+
+// sendPasswordResetEmail отправляет ссылку для сброса пароля, если пользователь с таким email существует
+func (h *Handler) sendPasswordResetEmail(email string) {
+	user, err := h.Users.GetByEmail(email)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("Failed to look up user for password reset: %v", err)
+		}
+		return
+	}
+
+	token, err := auth.IssueOneTimeToken(user.ID, auth.PurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		log.Printf("Failed to issue password reset token for user %d: %v", user.ID, err)
+		return
+	}
+
+	// Страницы сброса пароля у API нет, поэтому письмо содержит только токен для POST /auth/password/reset
+	err = mailer.Default.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello, %s!\n\nTo set a new password, send the token below together with the new password "+
+			"to POST /auth/password/reset:\n\nToken: %s\n\nThe token is valid for 1 hour and can be used once. "+
+			"If you did not request a password reset, just ignore this email.\n", user.Nickname, token),
+	})
+	if err != nil {
+		log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"apiForSN/auth"
+	"apiForSN/jobs"
+	"apiForSN/repository"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errPostNotFound возвращается из транзакции восстановления комментария, если его пост удалён
+var errPostNotFound = errors.New("post not found")
+
+// errDeletionStarted возвращается из транзакции восстановления аккаунта, если задача его удаления уже запущена
+var errDeletionStarted = errors.New("account deletion started")
+
+// Блок восстановления удалённых данных
+
+// RestoreAccount восстанавливает удалённый аккаунт до окончания срока восстановления.
+// Токены удалённого пользователя отозваны, поэтому владелец подтверждает себя email и паролем
+func (h *Handler) RestoreAccount(c *gin.Context) {
+	var credentials struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if credentials.Email == "" || credentials.Password == "" {
+		c.JSON(400, gin.H{"error": "Email and password are required"})
+		return
+	}
+
+	// Подбор пароля ограничивается так же, как при входе
+	email := strings.ToLower(strings.TrimSpace(credentials.Email))
+	if !checkLockout(c, email) {
+		return
+	}
+
+	user, err := h.Users.GetDeletedByEmail(email)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if err != nil {
+		auth.Passwords.Verify(dummyPasswordHash(), credentials.Password)
+		recordFailedAttempt(c, email)
+		c.JSON(401, gin.H{"error": invalidCredentialsMessage})
+		return
+	}
+	if !verifyPassword(*user, credentials.Password) {
+		recordFailedAttempt(c, email)
+		c.JSON(401, gin.H{"error": invalidCredentialsMessage})
+		return
+	}
+
+	if !jobs.Restorable(*user.DeletedAt, time.Now()) {
+		c.JSON(410, gin.H{"error": "Restore window has expired"})
+		return
+	}
+	err = h.Transaction(func(tx repository.Repositories) error {
+		if err := tx.Users.Restore(user.ID); err != nil {
+			return err
+		}
+		// Задача удаления, которая уже начала обрабатывать данные, не отменяется
+		if err := tx.AccountDeletions.CancelScheduled(user.ID, time.Now()); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return errDeletionStarted
+			}
+			return err
+		}
+		return nil
+	})
+	if errors.Is(err, errDeletionStarted) {
+		c.JSON(410, gin.H{"error": "Restore window has expired"})
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		// Восстановлен параллельным запросом
+		c.JSON(404, gin.H{"error": "Deleted account not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to restore account"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Account restored successfully, log in to continue"})
+}
+
+// RestorePost возвращает удалённый пост вместе с комментариями, скрытыми при его удалении.
+// Автор восстанавливает только то, что удалил сам; удалённое модератором возвращает модератор
+func (h *Handler) RestorePost(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	post, err := h.Posts.GetDeleted(c.GetInt("postID"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Deleted post not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if !canRestore(c, post.UserID, post.DeletedBy) {
+		c.JSON(403, gin.H{"error": "You can only restore posts you deleted yourself"})
+		return
+	}
+	if !jobs.Restorable(*post.DeletedAt, time.Now()) {
+		c.JSON(410, gin.H{"error": "Restore window has expired"})
+		return
+	}
+
+	moderated := userID != post.UserID
+	err = h.Transaction(func(tx repository.Repositories) error {
+		if err := tx.Posts.Restore(post.ID); err != nil {
+			return err
+		}
+		if moderated {
+			return recordAdminAction(tx, c, "post.restore", "post", post.ID, "")
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		// Восстановлен параллельным запросом
+		c.JSON(404, gin.H{"error": "Deleted post not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to restore post"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Post restored successfully"})
+}
+
+// RestoreComment возвращает удалённый комментарий. Пост комментария должен быть не удалён
+func (h *Handler) RestoreComment(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	comment, err := h.Comments.GetDeleted(c.GetInt("commentID"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Deleted comment not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+	if !canRestore(c, comment.UserID, comment.DeletedBy) {
+		c.JSON(403, gin.H{"error": "You can only restore comments you deleted yourself"})
+		return
+	}
+	if !jobs.Restorable(*comment.DeletedAt, time.Now()) {
+		c.JSON(410, gin.H{"error": "Restore window has expired"})
+		return
+	}
+
+	moderated := userID != comment.UserID
+	err = h.Transaction(func(tx repository.Repositories) error {
+		// Блокируем пост, как при создании комментария: счётчик меняется по очереди
+		if _, err := tx.Posts.LockByID(comment.PostID); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return errPostNotFound
+			}
+			return err
+		}
+		if err := tx.Comments.Restore(comment.ID); err != nil {
+			return err
+		}
+		if err := tx.Posts.AddComments(comment.PostID, 1); err != nil {
+			return err
+		}
+		if moderated {
+			return recordAdminAction(tx, c, "comment.restore", "comment", comment.ID, "")
+		}
+		return nil
+	})
+	if errors.Is(err, errPostNotFound) {
+		c.JSON(404, gin.H{"error": "Post not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		// Восстановлен параллельным запросом
+		c.JSON(404, gin.H{"error": "Deleted comment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to restore comment"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Comment restored successfully"})
+}
+
+// canRestore сообщает, может ли текущий пользователь восстановить запись автора ownerID, удалённую deletedBy
+func canRestore(c *gin.Context, ownerID int, deletedBy *int) bool {
+	if canModerate(c) {
+		return true
+	}
+	userID := c.GetInt("userID")
+	return userID == ownerID && deletedBy != nil && *deletedBy == userID
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"apiForSN/auth"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Блок управления сессиями (устройствами)
+func (h *Handler) ListSessions(c *gin.Context) {
+	sessions, err := auth.ListSessions(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Помечаем сессию, из которой пришёл запрос
+	var currentID string
+	value, _ := c.Get("claims")
+	if claims, ok := value.(*auth.Claims); ok {
+		currentID = claims.SessionID
+	}
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"id":           session.ID,
+			"user_agent":   session.UserAgent,
+			"ip":           session.IP,
+			"created_at":   session.CreatedAt,
+			"last_seen_at": session.LastSeenAt,
+			"current":      session.ID == currentID,
+		})
+	}
+	c.JSON(200, gin.H{"sessions": result})
+}
+
+func (h *Handler) RevokeSession(c *gin.Context) {
+	err := auth.RevokeSession(c.GetInt("userID"), c.Param("id"))
+	if errors.Is(err, auth.ErrSessionNotFound) {
+		c.JSON(404, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Session revoked successfully"})
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"apiForSN/auth"
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Блок двухфакторной аутентификации (TOTP)
+func (h *Handler) EnrollTOTP(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(409, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	// Коды восстановления хешируются так же, как пароли
+	records := make([]models.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		hash, err := hashRecoveryCode(code)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to hash recovery codes"})
+			return
+		}
+		records = append(records, models.RecoveryCode{UserID: user.ID, CodeHash: hash})
+	}
+
+	// Секрет сохраняется сразу, но 2FA включается только после подтверждения кодом из приложения
+	user.TOTPSecret = secret
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	err = h.Transaction(func(tx repository.Repositories) error {
+		if err := tx.RecoveryCodes.Replace(user.ID, records); err != nil {
+			return err
+		}
+		return tx.Users.Update(user, repository.FieldTOTPSecret, repository.FieldTOTPEnabled, repository.FieldTOTPLastStep)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"secret":         secret,
+		"otpauth_uri":    auth.TOTPURI(secret, user.Email),
+		"recovery_codes": codes,
+	})
+}
+
+func (h *Handler) EnableTOTP(c *gin.Context) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.Code == "" {
+		c.JSON(400, gin.H{"error": "code is required"})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(409, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(400, gin.H{"error": "Start enrollment first"})
+		return
+	}
+
+	step, valid := auth.VerifyTOTP(user.TOTPSecret, request.Code, time.Now())
+	if !valid {
+		c.JSON(400, gin.H{"error": "Invalid code"})
+		return
+	}
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	if err := h.Users.Update(user, repository.FieldTOTPEnabled, repository.FieldTOTPLastStep); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Two-factor authentication enabled"})
+}
+
+func (h *Handler) DisableTOTP(c *gin.Context) {
+	var request struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.Password == "" {
+		c.JSON(400, gin.H{"error": "password and code are required"})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	// Отключение требует и пароль, и второй фактор
+	if !checkLockout(c, user.Email) {
+		return
+	}
+	if !verifyPassword(*user, request.Password) {
+		recordFailedAttempt(c, user.Email)
+		c.JSON(401, gin.H{"error": "Invalid password"})
+		return
+	}
+	valid, err := h.verifySecondFactor(*user, request.Code, request.RecoveryCode)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		recordFailedAttempt(c, user.Email)
+		c.JSON(401, gin.H{"error": "Invalid code"})
+		return
+	}
+	recordSuccessfulAttempt(user.Email)
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	err = h.Transaction(func(tx repository.Repositories) error {
+		if err := tx.RecoveryCodes.Replace(user.ID, nil); err != nil {
+			return err
+		}
+		return tx.Users.Update(user, repository.FieldTOTPSecret, repository.FieldTOTPEnabled, repository.FieldTOTPLastStep)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// LoginMFA обменивает промежуточный токен и TOTP-код (или код восстановления) на полноценные токены
+func (h *Handler) LoginMFA(c *gin.Context) {
+	var request struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.MFAToken == "" || (request.Code == "" && request.RecoveryCode == "") {
+		c.JSON(400, gin.H{"error": "mfa_token and code or recovery_code are required"})
+		return
+	}
+
+	claims, err := auth.ParseToken(request.MFAToken, auth.TokenTypeMFAPending)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+	// Смена пароля или «выход везде» отзывают и незавершённые входы
+	if revoked, err := auth.Revocations.IsRevoked(claims); err != nil || revoked {
+		c.JSON(401, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	user, err := h.Users.GetByID(claims.UserID)
+	if err != nil || !user.TOTPEnabled {
+		c.JSON(401, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	// Коды второго фактора перебираются так же, как пароли, поэтому действует та же блокировка
+	if !checkLockout(c, user.Email) {
+		return
+	}
+	valid, err := h.verifySecondFactor(*user, request.Code, request.RecoveryCode)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		recordFailedAttempt(c, user.Email)
+		c.JSON(401, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	recordSuccessfulAttempt(user.Email)
+	// Промежуточный токен одноразовый: после обмена повторно его не принять
+	if err := auth.Revocations.RevokeToken(claims.ID, user.ID, claims.ExpiresAt.Time); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke MFA token"})
+		return
+	}
+	respondWithToken(c, 200, *user, nil)
+}
+
+// verifySecondFactor проверяет TOTP-код или, если он не передан, код восстановления.
+// Принятый код погашается: TOTP-код нельзя использовать повторно, код восстановления — тем более
+func (h *Handler) verifySecondFactor(user models.User, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, valid := auth.VerifyTOTP(user.TOTPSecret, code, time.Now())
+		if !valid {
+			return false, nil
+		}
+		// Условное обновление защищает от повторного использования кода, в том числе параллельного
+		return h.Users.AdvanceTOTPStep(user.ID, step)
+	}
+
+	if recoveryCode == "" {
+		return false, nil
+	}
+	normalized := auth.NormalizeRecoveryCode(recoveryCode)
+
+	codes, err := h.RecoveryCodes.ListUnused(user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, stored := range codes {
+		ok, _, err := auth.Passwords.Verify(stored.CodeHash, normalized)
+		if err != nil {
+			log.Printf("Failed to verify recovery code %d of user %d: %v", stored.ID, user.ID, err)
+		}
+		if !ok {
+			continue
+		}
+		return h.RecoveryCodes.MarkUsed(stored.ID, time.Now())
+	}
+	return false, nil
+}
+
+// hashRecoveryCode хеширует код восстановления тем же алгоритмом и с теми же параметрами, что и пароли
+func hashRecoveryCode(code string) (string, error) {
+	return auth.Passwords.Hash(code)
+}
+
+// currentUser загружает аутентифицированного пользователя. При ошибке ответ уже отправлен
+func (h *Handler) currentUser(c *gin.Context) (*models.User, bool) {
+	user, err := h.Users.GetByID(c.GetInt("userID"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Database error"})
+		}
+		return nil, false
+	}
+	return user, true
+}
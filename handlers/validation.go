@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"apiForSN/repository"
+	"errors"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ограничения на данные пользователя
+const (
+	minNicknameLength = 3
+	maxNicknameLength = 50
+	maxEmailLength    = 100
+	minPasswordLength = 8
+	// bcrypt учитывает только первые 72 байта пароля. Ограничение действует и для argon2id,
+	// чтобы при смене алгоритма любой пароль можно было перехешировать
+	maxPasswordBytes = 72
+)
+
+// normalizeEmail проверяет формат адреса и приводит его к нижнему регистру
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return "", errors.New("Email is required")
+	}
+	if len(email) > maxEmailLength {
+		return "", errors.New("Email is too long")
+	}
+	// Адрес должен быть «голым», без отображаемого имени вида "Name <addr>"
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return "", errors.New("Invalid email format")
+	}
+	// Домен заглушки зарезервирован целиком, а не только её адрес
+	if strings.HasSuffix(email, "@"+tombstoneEmailDomain) {
+		return "", errors.New("Email is reserved")
+	}
+	return email, nil
+}
+
+// Домен email пользователя-заглушки
+var tombstoneEmailDomain = repository.TombstoneEmail[strings.LastIndex(repository.TombstoneEmail, "@")+1:]
+
+// validateNickname проверяет длину никнейма в символах и что он не совпадает с ником заглушки
+func validateNickname(nickname string) error {
+	length := utf8.RuneCountInString(nickname)
+	if length < minNicknameLength || length > maxNicknameLength {
+		return errors.New("Nickname must be between 3 and 50 characters long")
+	}
+	// Ники сравниваются без учёта регистра, как в индексе users_nickname_key
+	if strings.EqualFold(nickname, repository.TombstoneNickname) {
+		return errors.New("Nickname is reserved")
+	}
+	return nil
+}
+
+// validatePassword проверяет надёжность пароля: длину и наличие букв в обоих регистрах и цифр
+func validatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return errors.New("Password must be at least 8 characters long")
+	}
+	if len(password) > maxPasswordBytes {
+		return errors.New("Password must be at most 72 bytes long")
+	}
+
+	var hasUpper, hasLower, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasUpper || !hasLower || !hasDigit {
+		return errors.New("Password must contain upper and lower case letters and digits")
+	}
+	return nil
+}
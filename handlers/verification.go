@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"apiForSN/auth"
+	"apiForSN/mailer"
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Срок действия ссылки для подтверждения email
+const emailVerificationTTL = 24 * time.Hour
+
+// Блок подтверждения email
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	_, err := auth.ConsumeOneTimeToken(token, auth.PurposeEmailVerification, func(tx repository.Repositories, userID int) error {
+		user, err := tx.Users.GetByID(userID)
+		if err != nil {
+			return err
+		}
+		user.EmailVerified = true
+		return tx.Users.Update(user, repository.FieldEmailVerified)
+	})
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidOneTimeToken) {
+			c.JSON(400, gin.H{"error": "Invalid or expired verification token"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerificationEmail повторно отправляет письмо для подтверждения email текущего пользователя
+func (h *Handler) ResendVerificationEmail(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(400, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	if err := sendVerificationEmail(*user); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Verification email sent"})
+}
+
+// sendVerificationEmail выпускает токен подтверждения и отправляет ссылку на адрес пользователя
+func sendVerificationEmail(user models.User) error {
+	token, err := auth.IssueOneTimeToken(user.ID, auth.PurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := appURL("/auth/verify", url.Values{"token": {token}})
+	return mailer.Default.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello, %s!\n\nPlease confirm your email address by opening the link below:\n\n%s\n\n"+
+			"The link is valid for 24 hours. If you did not sign up, just ignore this email.\n", user.Nickname, link),
+	})
+}
+
+// appURL строит абсолютную ссылку на API по адресу из APP_BASE_URL
+func appURL(path string, query url.Values) string {
+	base := strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return base + path + "?" + query.Encode()
+}
@@ -0,0 +1,273 @@
+package jobs
+
+import (
+	"apiForSN/models"
+	"apiForSN/repository"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+)
+
+// Количество лайков, комментариев или постов, которые обрабатываются в одной транзакции
+const accountDeletionBatchSize = 100
+
+// Задача, которая не обновлялась дольше этого срока, считается брошенной остановленным процессом и запускается заново
+const accountDeletionStaleAfter = 10 * time.Minute
+
+// AccountDeletionInterval — период проверки задач удаления аккаунтов. Задаётся ACCOUNT_DELETION_INTERVAL, по умолчанию минута
+func AccountDeletionInterval() time.Duration {
+	return durationFromEnv("ACCOUNT_DELETION_INTERVAL", time.Minute)
+}
+
+// ValidDeletionMode сообщает, известен ли режим удаления аккаунта
+func ValidDeletionMode(mode string) bool {
+	return mode == models.DeletionAnonymize || mode == models.DeletionErase
+}
+
+// ScheduleAccountDeletion планирует удаление аккаунта после окончания срока восстановления.
+// Вызывается в транзакции tx вместе с пометкой пользователя удалённым
+func ScheduleAccountDeletion(tx repository.Repositories, userID int, mode string, deletedAt time.Time) (*models.AccountDeletion, error) {
+	id, err := newDeletionID()
+	if err != nil {
+		return nil, err
+	}
+	deletion := &models.AccountDeletion{
+		ID:       id,
+		UserID:   userID,
+		Mode:     mode,
+		Status:   models.DeletionScheduled,
+		RunAfter: deletedAt.Add(RestoreWindow()),
+	}
+	if err := tx.AccountDeletions.Create(deletion); err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+// newDeletionID генерирует случайный идентификатор задачи: по нему без авторизации можно узнать состояние удаления
+func newDeletionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// RunAccountDeletions планирует удаление пользователей, оставшихся без задачи, и выполняет все задачи, время которых наступило
+func RunAccountDeletions(repos repository.Repositories) error {
+	if err := scheduleMissingAccountDeletions(repos); err != nil {
+		return err
+	}
+	for {
+		deletion, err := claimAccountDeletion(repos, time.Now())
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := runAccountDeletion(repos, deletion); err != nil {
+			// Задача остаётся в состоянии running и будет повторена, когда станет считаться брошенной.
+			// Все шаги можно безопасно выполнить повторно
+			deletion.Error = err.Error()
+			if updateErr := repos.AccountDeletions.Update(deletion); updateErr != nil {
+				log.Printf("Failed to save account deletion %s: %v", deletion.ID, updateErr)
+			}
+			return err
+		}
+	}
+}
+
+// scheduleMissingAccountDeletions создаёт задачи полного удаления для пользователей, удалённых до появления
+// задач (миграция 0005_account_deletions). Срок считается от времени удаления с текущим RESTORE_WINDOW
+func scheduleMissingAccountDeletions(repos repository.Repositories) error {
+	for {
+		var scheduled int
+		err := repos.Transaction(func(tx repository.Repositories) error {
+			users, err := tx.AccountDeletions.ListUnscheduledUsers(accountDeletionBatchSize)
+			if err != nil {
+				return err
+			}
+			for _, user := range users {
+				if _, err := ScheduleAccountDeletion(tx, user.ID, models.DeletionErase, *user.DeletedAt); err != nil {
+					return err
+				}
+			}
+			scheduled = len(users)
+			return nil
+		})
+		if errors.Is(err, repository.ErrDuplicate) {
+			// Задачи параллельно создал другой процесс
+			return nil
+		}
+		if err != nil || scheduled == 0 {
+			return err
+		}
+		log.Printf("Scheduled deletion of %d previously deleted accounts", scheduled)
+	}
+}
+
+// claimAccountDeletion переводит очередную задачу в состояние running и считает, сколько данных осталось обработать
+func claimAccountDeletion(repos repository.Repositories, now time.Time) (*models.AccountDeletion, error) {
+	var deletion *models.AccountDeletion
+	err := repos.Transaction(func(tx repository.Repositories) error {
+		var err error
+		deletion, err = tx.AccountDeletions.LockDue(now, now.Add(-accountDeletionStaleAfter))
+		if err != nil {
+			return err
+		}
+
+		remaining := int64(0)
+		for _, count := range []func(int) (int64, error){tx.Likes.CountByUser, tx.Comments.CountByUser, tx.Posts.CountByUser} {
+			n, err := count(deletion.UserID)
+			if err != nil {
+				return err
+			}
+			remaining += n
+		}
+
+		deletion.Status = models.DeletionRunning
+		deletion.Total = deletion.Processed + remaining
+		if deletion.StartedAt == nil {
+			deletion.StartedAt = &now
+		}
+		return tx.AccountDeletions.Update(deletion)
+	})
+	return deletion, err
+}
+
+// runAccountDeletion удаляет лайки пользователя, затем передаёт заглушке или удаляет его комментарии и посты,
+// и в конце удаляет сам аккаунт. Каждая порция обрабатывается в своей транзакции вместе с сохранением прогресса
+func runAccountDeletion(repos repository.Repositories, deletion *models.AccountDeletion) error {
+	steps := []func(tx repository.Repositories) (int64, error){
+		func(tx repository.Repositories) (int64, error) { return removeLikes(tx, deletion.UserID) },
+	}
+	if deletion.Mode == models.DeletionAnonymize {
+		steps = append(steps,
+			func(tx repository.Repositories) (int64, error) {
+				return reassignToTombstone(tx, tx.Comments.Reassign, deletion.UserID)
+			},
+			func(tx repository.Repositories) (int64, error) {
+				return reassignToTombstone(tx, tx.Posts.Reassign, deletion.UserID)
+			},
+		)
+	} else {
+		steps = append(steps,
+			func(tx repository.Repositories) (int64, error) { return eraseComments(tx, deletion.UserID) },
+			func(tx repository.Repositories) (int64, error) { return erasePosts(tx, deletion.UserID) },
+		)
+	}
+
+	for _, step := range steps {
+		for {
+			// Прогресс меняется в копии, чтобы откат транзакции не оставил в задаче несохранённые значения
+			progress := *deletion
+			err := repos.Transaction(func(tx repository.Repositories) error {
+				processed, err := step(tx)
+				if err != nil || processed == 0 {
+					return err
+				}
+				progress.Processed += processed
+				return tx.AccountDeletions.Update(&progress)
+			})
+			if err != nil {
+				return err
+			}
+			if progress.Processed == deletion.Processed {
+				break
+			}
+			*deletion = progress
+		}
+	}
+
+	completed := *deletion
+	err := repos.Transaction(func(tx repository.Repositories) error {
+		// Оставшиеся данные пользователя (сессии, токены, ключи) удаляет база вместе с ним
+		if err := tx.Users.Delete(deletion.UserID); err != nil {
+			return err
+		}
+		finishedAt := time.Now()
+		completed.Status = models.DeletionCompleted
+		completed.Error = ""
+		completed.FinishedAt = &finishedAt
+		return tx.AccountDeletions.Update(&completed)
+	})
+	if err != nil {
+		return err
+	}
+	*deletion = completed
+	return nil
+}
+
+// removeLikes удаляет порцию лайков пользователя и уменьшает счётчики лайкнутых постов и комментариев
+func removeLikes(tx repository.Repositories, userID int) (int64, error) {
+	likes, err := tx.Likes.ListByUser(userID, accountDeletionBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, like := range likes {
+		if err := tx.Likes.Delete(like.ID); err != nil {
+			return 0, err
+		}
+		if like.PostID != nil {
+			err = tx.Posts.AddLikes(*like.PostID, -1)
+		} else if like.CommentID != nil {
+			err = tx.Comments.AddLikes(*like.CommentID, -1)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(likes)), nil
+}
+
+// reassignToTombstone передаёт порцию комментариев или постов пользователя заглушке
+func reassignToTombstone(tx repository.Repositories, reassign func(fromUserID, toUserID int, limit int) (int64, error), userID int) (int64, error) {
+	tombstone, err := tx.Users.Tombstone()
+	if err != nil {
+		return 0, err
+	}
+	return reassign(userID, tombstone.ID, accountDeletionBatchSize)
+}
+
+// eraseComments удаляет порцию комментариев пользователя. Счётчики учитывают только неудалённые комментарии
+func eraseComments(tx repository.Repositories, userID int) (int64, error) {
+	comments, err := tx.Comments.ListByUser(userID, accountDeletionBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, comment := range comments {
+		if err := tx.Comments.Delete(comment.ID); err != nil {
+			return 0, err
+		}
+		if comment.DeletedAt == nil {
+			if err := tx.Posts.AddComments(comment.PostID, -1); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return int64(len(comments)), nil
+}
+
+// erasePosts удаляет порцию постов пользователя вместе с чужими комментариями и лайками к ним
+func erasePosts(tx repository.Repositories, userID int) (int64, error) {
+	posts, err := tx.Posts.ListByUser(userID, accountDeletionBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, post := range posts {
+		if err := tx.Posts.Delete(post.ID); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(posts)), nil
+}
+
+// StartAccountDeletions периодически выполняет задачи удаления аккаунтов
+func StartAccountDeletions(repos repository.Repositories, interval time.Duration) {
+	every(interval, "account deletion", func() error {
+		return RunAccountDeletions(repos)
+	})
+}
@@ -0,0 +1,63 @@
+package jobs
+
+import (
+	"apiForSN/repository"
+	"log"
+	"time"
+)
+
+// CounterReport — итог сверки денормализованных счётчиков лайков и комментариев
+type CounterReport struct {
+	DryRun bool
+	// Posts и Comments — число строк, в которых счётчики расходились с таблицами likes и comments
+	Posts    int64
+	Comments int64
+}
+
+// Corrected возвращает число исправленных строк. При пробном запуске ничего не исправляется
+func (r CounterReport) Corrected() int64 {
+	if r.DryRun {
+		return 0
+	}
+	return r.Posts + r.Comments
+}
+
+// CounterReconcileInterval — период фоновой сверки счётчиков. Задаётся COUNTER_RECONCILE_INTERVAL, по умолчанию час
+func CounterReconcileInterval() time.Duration {
+	return durationFromEnv("COUNTER_RECONCILE_INTERVAL", time.Hour)
+}
+
+// ReconcileCounters пересчитывает счётчики постов и комментариев по фактическим лайкам и комментариям.
+// При dryRun только считает строки с расхождением. Посты и комментарии сверяются в отдельных транзакциях,
+// чтобы не держать блокировки обеих таблиц одновременно
+func ReconcileCounters(repos repository.Repositories, dryRun bool) (CounterReport, error) {
+	report := CounterReport{DryRun: dryRun}
+	err := repos.Transaction(func(tx repository.Repositories) error {
+		var err error
+		report.Posts, err = tx.Posts.RecountCounters(dryRun)
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+	err = repos.Transaction(func(tx repository.Repositories) error {
+		var err error
+		report.Comments, err = tx.Comments.RecountCounters(dryRun)
+		return err
+	})
+	return report, err
+}
+
+// StartCounterReconciler периодически исправляет счётчики в фоне
+func StartCounterReconciler(repos repository.Repositories, interval time.Duration) {
+	every(interval, "counter reconciliation", func() error {
+		report, err := ReconcileCounters(repos, false)
+		if err != nil {
+			return err
+		}
+		if report.Corrected() > 0 {
+			log.Printf("Counter reconciliation corrected %d posts and %d comments", report.Posts, report.Comments)
+		}
+		return nil
+	})
+}
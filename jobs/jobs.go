@@ -0,0 +1,35 @@
+// Package jobs содержит фоновые задачи обслуживания данных, которые выполняются по расписанию
+// и по запросу администратора
+package jobs
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// every запускает task с интервалом interval в отдельной горутине. Ошибки записываются в лог
+func every(interval time.Duration, name string, task func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := task(); err != nil {
+				log.Printf("Job %s failed: %v", name, err)
+			}
+		}
+	}()
+}
+
+// durationFromEnv читает длительность из переменной окружения, при отсутствии или ошибке возвращает значение по умолчанию
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
@@ -0,0 +1,215 @@
+package jobs_test
+
+import (
+	"apiForSN/jobs"
+	"apiForSN/models"
+	"apiForSN/repository"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fixture — два пользователя в хранилище в памяти: у alice пост с комментарием bob, у bob пост
+// с комментарием и лайком alice
+type fixture struct {
+	repos             repository.Repositories
+	alice, bob        models.User
+	alicePost         models.Post
+	bobPost           models.Post
+	bobComment        models.Comment
+	aliceCommentOnBob models.Comment
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{
+		repos: repository.NewMemory(),
+		alice: models.User{Nickname: "alice", Email: "alice@example.com"},
+		bob:   models.User{Nickname: "bob", Email: "bob@example.com"},
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(f.repos.Users.Create(&f.alice))
+	must(f.repos.Users.Create(&f.bob))
+
+	f.alicePost = models.Post{UserID: f.alice.ID, Content: "alice's post"}
+	must(f.repos.Posts.Create(&f.alicePost))
+	f.bobPost = models.Post{UserID: f.bob.ID, Content: "bob's post"}
+	must(f.repos.Posts.Create(&f.bobPost))
+
+	f.bobComment = models.Comment{UserID: f.bob.ID, PostID: f.alicePost.ID, Content: "bob's comment"}
+	must(f.repos.Comments.Create(&f.bobComment))
+	must(f.repos.Posts.AddComments(f.alicePost.ID, 1))
+	f.aliceCommentOnBob = models.Comment{UserID: f.alice.ID, PostID: f.bobPost.ID, Content: "alice's comment"}
+	must(f.repos.Comments.Create(&f.aliceCommentOnBob))
+	must(f.repos.Posts.AddComments(f.bobPost.ID, 1))
+
+	must(f.repos.Likes.Create(&models.Like{UserID: f.alice.ID, PostID: &f.bobPost.ID}))
+	must(f.repos.Posts.AddLikes(f.bobPost.ID, 1))
+	return f
+}
+
+func TestReconcileCountersDryRunAndFix(t *testing.T) {
+	f := newFixture(t)
+	// Счётчики поста bob и комментария bob расходятся с таблицами
+	if err := f.repos.Posts.AddLikes(f.bobPost.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.repos.Comments.AddLikes(f.bobComment.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := jobs.ReconcileCounters(f.repos, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Posts != 1 || report.Comments != 1 || report.Corrected() != 0 {
+		t.Errorf("dry run report = %+v, corrected %d", report, report.Corrected())
+	}
+	if post, _ := f.repos.Posts.GetByID(f.bobPost.ID); post.Likes != 4 {
+		t.Errorf("dry run changed likes to %d", post.Likes)
+	}
+
+	report, err = jobs.ReconcileCounters(f.repos, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Posts != 1 || report.Comments != 1 || report.Corrected() != 2 {
+		t.Errorf("fix report = %+v, corrected %d", report, report.Corrected())
+	}
+	if post, _ := f.repos.Posts.GetByID(f.bobPost.ID); post.Likes != 1 {
+		t.Errorf("post likes = %d, want 1", post.Likes)
+	}
+	if comment, _ := f.repos.Comments.GetByID(f.bobComment.ID); comment.Likes != 0 {
+		t.Errorf("comment likes = %d, want 0", comment.Likes)
+	}
+
+	if report, err := jobs.ReconcileCounters(f.repos, true); err != nil || report.Posts+report.Comments != 0 {
+		t.Errorf("after fix: report = %+v, err = %v", report, err)
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	t.Setenv("RESTORE_WINDOW", "1h")
+	f := newFixture(t)
+	now := time.Now()
+
+	// Пост alice удалён давно, пост bob — недавно, комментарий bob к посту alice уходит вместе с постом
+	if err := f.repos.Posts.SoftDelete(f.alicePost.ID, f.alice.ID, now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.repos.Posts.SoftDelete(f.bobPost.ID, f.bob.ID, now.Add(-30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !jobs.Restorable(now.Add(-30*time.Minute), now) || jobs.Restorable(now.Add(-2*time.Hour), now) {
+		t.Error("Restorable does not follow RESTORE_WINDOW")
+	}
+
+	report, err := jobs.PurgeDeleted(f.repos, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Posts != 1 {
+		t.Errorf("purged posts = %d, want 1", report.Posts)
+	}
+	if _, err := f.repos.Posts.GetDeleted(f.alicePost.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expired post: err = %v, want ErrNotFound", err)
+	}
+	if _, err := f.repos.Comments.GetDeleted(f.bobComment.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("comment of the expired post: err = %v, want ErrNotFound", err)
+	}
+	if _, err := f.repos.Posts.GetDeleted(f.bobPost.ID); err != nil {
+		t.Errorf("post within the window was purged: %v", err)
+	}
+	if err := f.repos.Posts.Restore(f.bobPost.ID); err != nil {
+		t.Errorf("restore within the window: %v", err)
+	}
+}
+
+func TestAccountDeletionModes(t *testing.T) {
+	for _, mode := range []string{models.DeletionAnonymize, models.DeletionErase} {
+		t.Run(mode, func(t *testing.T) {
+			f := newFixture(t)
+			// Срок восстановления уже истёк, поэтому задача выполняется при первом запуске
+			deletedAt := time.Now().Add(-jobs.RestoreWindow() - time.Minute)
+			var deletion *models.AccountDeletion
+			err := f.repos.Transaction(func(tx repository.Repositories) error {
+				if err := tx.Users.SoftDelete(f.alice.ID, deletedAt); err != nil {
+					return err
+				}
+				var err error
+				deletion, err = jobs.ScheduleAccountDeletion(tx, f.alice.ID, mode, deletedAt)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := jobs.RunAccountDeletions(f.repos); err != nil {
+				t.Fatal(err)
+			}
+
+			done, err := f.repos.AccountDeletions.GetByID(deletion.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Лайк, пост и комментарий alice
+			if done.Status != models.DeletionCompleted || done.Total != 3 || done.Processed != 3 {
+				t.Errorf("deletion = %+v", done)
+			}
+			if _, err := f.repos.Users.GetDeletedByEmail(f.alice.Email); !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("account is not purged: err = %v", err)
+			}
+
+			// В обоих режимах лайк alice снят, а счётчик поста bob уменьшен
+			bobPost, err := f.repos.Posts.GetByID(f.bobPost.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bobPost.Likes != 0 {
+				t.Errorf("likes of bob's post = %d, want 0", bobPost.Likes)
+			}
+
+			alicePost, postErr := f.repos.Posts.GetByID(f.alicePost.ID)
+			_, commentErr := f.repos.Comments.GetByID(f.bobComment.ID)
+			aliceComment, aliceCommentErr := f.repos.Comments.GetByID(f.aliceCommentOnBob.ID)
+			if mode == models.DeletionAnonymize {
+				tombstone, err := f.repos.Users.Tombstone()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if postErr != nil || alicePost.UserID != tombstone.ID {
+					t.Errorf("post: %+v, err = %v, want it owned by the tombstone", alicePost, postErr)
+				}
+				if aliceCommentErr != nil || aliceComment.UserID != tombstone.ID {
+					t.Errorf("comment: %+v, err = %v, want it owned by the tombstone", aliceComment, aliceCommentErr)
+				}
+				if commentErr != nil {
+					t.Errorf("bob's comment under the anonymized post: %v", commentErr)
+				}
+				if bobPost.Comments != 1 {
+					t.Errorf("comments of bob's post = %d, want 1", bobPost.Comments)
+				}
+				return
+			}
+
+			// erase удаляет пост alice вместе с чужими комментариями и её комментарий к посту bob
+			if !errors.Is(postErr, repository.ErrNotFound) {
+				t.Errorf("erased post: err = %v, want ErrNotFound", postErr)
+			}
+			if !errors.Is(commentErr, repository.ErrNotFound) {
+				t.Errorf("bob's comment under the erased post: err = %v, want ErrNotFound", commentErr)
+			}
+			if !errors.Is(aliceCommentErr, repository.ErrNotFound) {
+				t.Errorf("erased comment: err = %v, want ErrNotFound", aliceCommentErr)
+			}
+			if bobPost.Comments != 0 {
+				t.Errorf("comments of bob's post = %d, want 0", bobPost.Comments)
+			}
+		})
+	}
+}
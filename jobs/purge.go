@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"apiForSN/repository"
+	"log"
+	"time"
+)
+
+// PurgeReport — число окончательно удалённых записей
+type PurgeReport struct {
+	Posts    int64
+	Comments int64
+}
+
+// RestoreWindow — срок, в течение которого удалённых пользователей, посты и комментарии можно восстановить.
+// Задаётся RESTORE_WINDOW, по умолчанию 30 дней
+func RestoreWindow() time.Duration {
+	return durationFromEnv("RESTORE_WINDOW", 30*24*time.Hour)
+}
+
+// PurgeInterval — период запуска очистки. Задаётся PURGE_INTERVAL, по умолчанию час
+func PurgeInterval() time.Duration {
+	return durationFromEnv("PURGE_INTERVAL", time.Hour)
+}
+
+// Restorable сообщает, не истёк ли срок восстановления записи, удалённой в deletedAt
+func Restorable(deletedAt time.Time, now time.Time) bool {
+	return now.Sub(deletedAt) <= RestoreWindow()
+}
+
+// PurgeDeleted окончательно удаляет посты и комментарии, срок восстановления которых истёк к now.
+// Лайки и комментарии удаляются вместе с постами (ON DELETE CASCADE). Удалённые аккаунты очищают
+// задачи удаления аккаунтов (см. RunAccountDeletions)
+func PurgeDeleted(repos repository.Repositories, now time.Time) (PurgeReport, error) {
+	var report PurgeReport
+	deletedBefore := now.Add(-RestoreWindow())
+
+	var err error
+	if report.Comments, err = repos.Comments.Purge(deletedBefore); err != nil {
+		return report, err
+	}
+	report.Posts, err = repos.Posts.Purge(deletedBefore)
+	return report, err
+}
+
+// StartPurger периодически удаляет записи с истёкшим сроком восстановления
+func StartPurger(repos repository.Repositories, interval time.Duration) {
+	every(interval, "purge", func() error {
+		report, err := PurgeDeleted(repos, time.Now())
+		if err != nil {
+			return err
+		}
+		if report.Posts+report.Comments > 0 {
+			log.Printf("Purged %d posts and %d comments", report.Posts, report.Comments)
+		}
+		return nil
+	})
+}
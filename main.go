@@ -1,11 +1,19 @@
 package main
 
 import (
+	"apiForSN/auth"
 	"apiForSN/db"
 	"apiForSN/handlers"
+	"apiForSN/jobs"
+	"apiForSN/mailer"
 	"apiForSN/middleware"
+	"apiForSN/repository"
+	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -13,50 +21,176 @@ import (
 )
 
 func main() {
-	// Подключаемся к .env
-	err := godotenv.Load()
+	storageKind := flag.String("storage", "db", "хранилище данных: db (Postgres или SQLite из ConnStr) или memory")
+	flag.Parse()
+
+	// Подключаемся к .env. Без файла настройки берутся из окружения
+	if err := godotenv.Load(); err != nil {
+		log.Println("Файл .env не найден, используются переменные окружения")
+	}
+
+	// Подкоманда migrate управляет схемой базы и не запускает сервер
+	if flag.NArg() > 0 && flag.Arg(0) == "migrate" {
+		db.Connect(os.Getenv("ConnStr"))
+		os.Exit(runMigrateCommand(flag.Args()[1:]))
+	}
+
+	// Загружаем ключи подписи токенов. Без ключей сервер не запускается.
+	// Исключение — хранилище в памяти без JWT_KEYS_DIR: тогда создаётся временный ключ
+	if *storageKind == "memory" && os.Getenv("JWT_KEYS_DIR") == "" {
+		if err := auth.GenerateEphemeralKey(); err != nil {
+			log.Fatalf("Ошибка создания временного ключа JWT: %v", err)
+		}
+		log.Println("JWT_KEYS_DIR не задан, токены подписываются временным ключом до перезапуска")
+	} else {
+		if err := auth.LoadKeys(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_SIGNING_KEY_ID")); err != nil {
+			log.Fatalf("Ошибка загрузки ключей JWT: %v", err)
+		}
+		// По SIGHUP перечитываем ключи, чтобы выполнить ротацию без перезапуска
+		reloadKeysOnSignal()
+	}
+
+	// Почтовый сервис для писем подтверждения и сброса пароля
+	mail, err := mailer.FromEnv()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatalf("Ошибка настройки почты: %v", err)
 	}
+	mailer.Default = mail
+
+	// Алгоритм и параметры хеширования паролей
+	passwords, err := auth.PasswordHasherFromEnv()
+	if err != nil {
+		log.Fatalf("Ошибка настройки хеширования паролей: %v", err)
+	}
+	auth.Passwords = passwords
+
+	// Выбираем хранилище. Обработчики и пакет auth работают с ним только через репозитории
+	var repos repository.Repositories
+	switch *storageKind {
+	// postgres — прежнее имя значения db, оставлено для совместимости: строка подключения может указывать и на SQLite
+	case "db", "postgres":
+		db.Connect(os.Getenv("ConnStr"))
+
+		// Применяем новые миграции схемы. Параллельно запущенные экземпляры дождутся друг друга
+		applied, err := db.MigrateUp()
+		if err != nil {
+			log.Fatalf("Ошибка применения миграций: %v", err)
+		}
+		for _, migration := range applied {
+			log.Printf("Применена миграция %d_%s", migration.Version, migration.Name)
+		}
+		repos = repository.NewGorm(db.DB)
+	case "memory":
+		log.Println("Данные хранятся в памяти и пропадут после остановки сервера")
+		repos = repository.NewMemory()
+	default:
+		log.Fatalf("Неизвестное хранилище %q, ожидается db или memory", *storageKind)
+	}
+	auth.UseStorage(repos)
+
+	// Время последней активности сессий записывается в хранилище раз в минуту
+	auth.Sessions.Start(time.Minute)
+
+	// Денормализованные счётчики лайков и комментариев периодически сверяются с таблицами
+	jobs.StartCounterReconciler(repos, jobs.CounterReconcileInterval())
 
-	// Подключение к базе данных
-	connStr := os.Getenv("ConnStr")
-	db.Connect(connStr)
-	db.InitTables()
+	// Удалённые записи окончательно удаляются после окончания срока восстановления
+	jobs.StartPurger(repos, jobs.PurgeInterval())
+
+	// Удалённые аккаунты вместе с их данными обрабатываются фоновыми задачами
+	jobs.StartAccountDeletions(repos, jobs.AccountDeletionInterval())
+
+	h := handlers.New(repos)
 
 	// Создание роутера
 	router := gin.Default()
 
-	// Применяем AuthMiddleware ко всем маршрутам, требующим авторизации
+	// Публичные ключи для проверки токенов другими сервисами
+	router.GET("/.well-known/jwks.json", h.JWKS)
+
+	// Маршруты регистрации, входа, выхода и подтверждения email. Часть из них доступна без токена
+	public := router.Group("/auth")
+	{
+		public.POST("/signup", h.CreateUser)
+		public.POST("/login", h.Login)
+		public.POST("/login/mfa", h.LoginMFA)
+		public.POST("/refresh", h.Refresh)
+		public.GET("/verify", h.VerifyEmail)
+		public.POST("/password/forgot", h.ForgotPassword)
+		public.POST("/password/reset", h.ResetPassword)
+		public.POST("/restore", h.RestoreAccount)
+		public.GET("/account-deletions/:id", h.GetAccountDeletion)
+
+		// Выход требует действующего токена
+		public.POST("/logout", middleware.AuthMiddleware(), h.Logout)
+		public.POST("/logout-all", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeAccount), h.LogoutAll)
+		public.POST("/verify/resend", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeAccount), h.ResendVerificationEmail)
+	}
+
+	// Применяем AuthMiddleware ко всем маршрутам, требующим авторизации.
+	// Маршруты принимают и JWT, и ключи API; права ключей ограничиваются через RequireScope
 	authorized := router.Group("/api")
 	authorized.Use(middleware.AuthMiddleware())
 	{
+		read := middleware.RequireScope(auth.ScopeRead)
+		account := middleware.RequireScope(auth.ScopeAccount)
+		postWrite := middleware.RequireScope(auth.ScopePostWrite)
+		commentWrite := middleware.RequireScope(auth.ScopeCommentWrite)
+
 		// Роуты для работы с пользователями
-		authorized.GET("/user", handlers.GetUser)
-		authorized.PUT("/user", handlers.UpdateUser)
-		authorized.DELETE("/users/:id", handlers.DeleteUser)
-		authorized.POST("/users", handlers.CreateUser)
+		authorized.GET("/user", read, h.GetUser)
+		authorized.PUT("/user", account, h.UpdateUser)
+		authorized.DELETE("/users/:id", account, h.DeleteUser)
+
+		// Роуты для двухфакторной аутентификации
+		authorized.POST("/user/2fa/enroll", account, h.EnrollTOTP)
+		authorized.POST("/user/2fa/enable", account, h.EnableTOTP)
+		authorized.POST("/user/2fa/disable", account, h.DisableTOTP)
+
+		// Роуты для персональных ключей API
+		authorized.POST("/keys", account, h.CreateAPIKey)
+		authorized.GET("/keys", account, h.ListAPIKeys)
+		authorized.DELETE("/keys/:id", account, h.RevokeAPIKey)
+
+		// Сессии на устройствах
+		authorized.GET("/sessions", account, h.ListSessions)
+		authorized.DELETE("/sessions/:id", account, h.RevokeSession)
 
 		// Роуты для постов
 		posts := authorized.Group("/posts")
 		{
+			// Создавать посты могут только пользователи с подтверждённым email.
+			// Маршрут регистрируется до PostIDMiddleware, так как postID у него нет
+			posts.POST("/", postWrite, middleware.RequireVerifiedEmail(h.Users), h.CreatePost)
+
 			posts.Use(middleware.PostIDMiddleware()) // Применяем middleware для postID
-			posts.GET("/:postID", handlers.GetPost)
-			posts.PUT("/:postID", handlers.UpdatePost)
-			posts.DELETE("/:postID", handlers.DeletePost)
-			posts.POST("/", handlers.CreatePost)
-			posts.POST("/:postID/like", handlers.LikePost)
+			posts.GET("/:postID", read, h.GetPost)
+			posts.PUT("/:postID", postWrite, h.UpdatePost)
+			posts.DELETE("/:postID", postWrite, h.DeletePost)
+			posts.POST("/:postID/restore", postWrite, h.RestorePost)
+			posts.POST("/:postID/like", postWrite, h.LikePost)
+			posts.POST("/:postID/comments", commentWrite, middleware.RequireVerifiedEmail(h.Users), h.CreateComment)
 		}
 
 		// Роуты для комментариев
 		comments := authorized.Group("/comments")
 		{
 			comments.Use(middleware.CommentIDMiddleware()) // Применяем middleware для commentID
-			comments.GET("/:commentID", handlers.GetComment)
-			comments.PUT("/:commentID", handlers.UpdateComment)
-			comments.DELETE("/:commentID", handlers.DeleteComment)
-			comments.POST("/", handlers.CreateComment)
-			comments.POST("/:commentID/like", handlers.LikeComment)
+			comments.GET("/:commentID", read, h.GetComment)
+			comments.PUT("/:commentID", commentWrite, h.UpdateComment)
+			comments.DELETE("/:commentID", commentWrite, h.DeleteComment)
+			comments.POST("/:commentID/restore", commentWrite, h.RestoreComment)
+			comments.POST("/:commentID/like", commentWrite, h.LikeComment)
+		}
+
+		// Роуты для администраторов
+		admin := authorized.Group("/admin")
+		{
+			admin.Use(middleware.RequireRole(auth.RoleAdmin))
+			admin.PUT("/users/:id/roles", h.UpdateUserRoles)
+			admin.POST("/users/:id/unlock", h.UnlockUser)
+			admin.GET("/actions", h.ListAdminActions)
+			admin.POST("/counters/reconcile", h.ReconcileCounters)
 		}
 	}
 
@@ -65,3 +199,18 @@ func main() {
 		log.Fatalf("Ошибка запуска сервера: %v", err)
 	}
 }
+
+// reloadKeysOnSignal перечитывает ключи JWT при получении SIGHUP. При ошибке продолжают работать прежние ключи
+func reloadKeysOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := auth.LoadKeys(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_SIGNING_KEY_ID")); err != nil {
+				log.Printf("Ошибка перезагрузки ключей JWT: %v", err)
+				continue
+			}
+			log.Println("Ключи JWT перезагружены")
+		}
+	}()
+}
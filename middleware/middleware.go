@@ -2,53 +2,139 @@ package middleware
 
 import (
 	"apiForSN/auth"
+	"apiForSN/repository"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 )
 
-// Middleware для проверки JWT токена
+// Middleware для проверки JWT токена или персонального ключа API
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Ключ API можно передать отдельным заголовком
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		// Получаем токен из заголовка Authorization
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is missing"})
-			c.Abort()
+			abortWithTokenError(c, auth.CodeTokenMissing, "Authorization header is missing")
 			return
 		}
 
 		// Токен должен начинаться с "Bearer "
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bearer token is missing"})
-			c.Abort()
+			abortWithTokenError(c, auth.CodeTokenMissing, "Bearer token is missing")
+			return
+		}
+
+		// Ключ API в заголовке Authorization: Bearer snk_...
+		if auth.IsAPIKey(tokenString) {
+			authenticateAPIKey(c, tokenString)
 			return
 		}
 
 		// Парсим и проверяем токен
-		claims := &auth.Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return auth.JwtKey, nil
-		})
+		claims, err := auth.ParseToken(tokenString, auth.TokenTypeAccess)
+		if err != nil {
+			var tokenErr *auth.TokenError
+			if errors.As(err, &tokenErr) {
+				abortWithTokenError(c, tokenErr.Code, tokenErr.Message)
+			} else {
+				abortWithTokenError(c, auth.CodeTokenMalformed, "Invalid token")
+			}
+			return
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		// Проверяем, не был ли токен отозван (выход, смена пароля, удаление аккаунта)
+		revoked, err := auth.Revocations.IsRevoked(claims)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify token"})
 			c.Abort()
 			return
 		}
+		if revoked {
+			abortWithTokenError(c, auth.CodeTokenRevoked, "Token has been revoked")
+			return
+		}
+
+		// Отмечаем активность сессии в памяти, в базу она попадает периодически пакетом
+		auth.Sessions.Touch(claims.SessionID)
 
-		// Сохраняем userID в контексте для дальнейшего использования
+		// Сохраняем userID, claims, роли и области доступа в контексте для дальнейшего использования
 		c.Set("userID", claims.UserID)
+		c.Set("claims", claims)
+		c.Set("roles", claims.Roles)
+		c.Set("scopes", auth.ScopesForRoles(claims.Roles))
 
 		// Переходим к следующему обработчику
 		c.Next()
 	}
 }
 
+// authenticateAPIKey проверяет ключ API и заполняет контекст так же, как для JWT, чтобы обработчики не различали способ входа.
+// Ролей у ключа нет: его права ограничены областями доступа
+func authenticateAPIKey(c *gin.Context, key string) {
+	apiKey, scopes, err := auth.AuthenticateAPIKey(key)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAPIKey) {
+			abortWithTokenError(c, auth.CodeAPIKeyInvalid, "Invalid or revoked API key")
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify API key"})
+		c.Abort()
+		return
+	}
+
+	c.Set("userID", apiKey.UserID)
+	c.Set("apiKeyID", apiKey.ID)
+	c.Set("roles", []string{})
+	c.Set("scopes", scopes)
+	c.Next()
+}
+
+// RequireRole пропускает запрос, только если у пользователя есть хотя бы одна из ролей.
+// Используется после AuthMiddleware
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userRoles := c.GetStringSlice("roles")
+		for _, role := range roles {
+			if auth.HasRole(userRoles, role) {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient role", "code": "insufficient_role"})
+		c.Abort()
+	}
+}
+
+// RequireScope пропускает запрос, только если у токена есть указанная область доступа.
+// Используется после AuthMiddleware
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.HasScope(c.GetStringSlice("scopes"), scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient scope", "code": "insufficient_scope", "required_scope": scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// abortWithTokenError прерывает запрос с ответом 401 и машиночитаемым кодом причины
+func abortWithTokenError(c *gin.Context, code, message string) {
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": message, "code": code})
+	c.Abort()
+}
+
 func PostIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		postID := c.Param("postID")
@@ -94,3 +180,22 @@ func CommentIDMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// RequireVerifiedEmail пропускает запрос только для пользователей с подтверждённым email.
+// Используется после AuthMiddleware
+func RequireVerifiedEmail(users repository.UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := users.GetByID(c.GetInt("userID"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+		if !user.EmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address is not verified", "code": "email_not_verified"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"apiForSN/db"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// runMigrateCommand выполняет подкоманду migrate up|down [N]|status и возвращает код выхода
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: migrate up | down [N] | status")
+		return 2
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp()
+		for _, migration := range applied {
+			fmt.Printf("applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate up:", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		// По умолчанию откатываем одну последнюю миграцию
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				fmt.Fprintln(os.Stderr, "migrate down: N must be a positive number")
+				return 2
+			}
+			steps = n
+		}
+		reverted, err := db.MigrateDown(steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate down:", err)
+			return 1
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no applied migrations")
+		}
+	case "status":
+		states, err := db.MigrationStatus()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate status:", err)
+			return 1
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = "applied " + state.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-40s %s\n", state.Version, state.Name, applied)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q, expected up, down or status\n", args[0])
+		return 2
+	}
+	return 0
+}
@@ -1,28 +1,115 @@
 package models
 
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
 type User struct {
-	ID       int    `json:"id" gorm:"primaryKey"`
-	Nickname string `json:"nickname"`
-	Email    string `json:"email"`
-	Password string `json:"-"`
+	ID            int    `json:"id" gorm:"primaryKey"`
+	Nickname      string `json:"nickname"`
+	Email         string `json:"email"`
+	Password      string `json:"-"`
+	EmailVerified bool   `json:"email_verified"`
+	// Двухфакторная аутентификация: секрет TOTP и номер последнего принятого интервала (защита от повтора кода)
+	TOTPSecret   string     `json:"-" gorm:"column:totp_secret"`
+	TOTPEnabled  bool       `json:"totp_enabled" gorm:"column:totp_enabled"`
+	TOTPLastStep int64      `json:"-" gorm:"column:totp_last_step"`
+	Roles        StringList `json:"roles" gorm:"column:roles"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	// DeletedAt — время удаления. До окончательной очистки аккаунт можно восстановить
+	DeletedAt *time.Time `json:"-"`
+}
+
+// StringList — список строк (роли, области доступа), который хранится в одной текстовой колонке через запятую
+type StringList []string
+
+func (r StringList) Value() (driver.Value, error) {
+	return strings.Join(r, ","), nil
+}
+
+func (r *StringList) Scan(src interface{}) error {
+	var value string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", src)
+	}
+
+	*r = nil
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			*r = append(*r, role)
+		}
+	}
+	return nil
+}
+
+// Has сообщает, есть ли значение в списке
+func (r StringList) Has(value string) bool {
+	for _, existing := range r {
+		if existing == value {
+			return true
+		}
+	}
+	return false
 }
 
 type Post struct {
-	ID       int    `json:"id" gorm:"primaryKey"`
-	UserID   int    `json:"user_id"`
-	Date     int    `json:"date"`
-	Content  string `json:"content"`
-	Likes    int    `json:"likes"`
-	Comments int    `json:"comments"`
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"user_id"`
+	Content   string     `json:"content"`
+	Likes     int        `json:"likes"`
+	Comments  int        `json:"comments"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+	// Version увеличивается при каждой правке текста и отдаётся клиентам как ETag
+	Version int `json:"version"`
+	// DeletedAt и DeletedBy — когда и кем удалена запись. До окончательной очистки её можно восстановить
+	DeletedAt *time.Time `json:"-"`
+	DeletedBy *int       `json:"-"`
+}
+
+// LegacyDate возвращает значение устаревшего поля date: Unix-время последней правки или создания.
+// Поле остаётся в ответах API на период перехода на created_at и edited_at
+func (p Post) LegacyDate() int64 {
+	return legacyDate(p.CreatedAt, p.EditedAt)
 }
 
 type Comment struct {
-	ID      int    `json:"id" gorm:"primaryKey"`
-	UserID  int    `json:"user_id"`
-	PostID  int    `json:"post_id"`
-	Date    int    `json:"date"`
-	Content string `json:"content"`
-	Likes   int    `json:"likes"`
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"user_id"`
+	PostID    int        `json:"post_id"`
+	Content   string     `json:"content"`
+	Likes     int        `json:"likes"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+	// Version увеличивается при каждой правке текста и отдаётся клиентам как ETag
+	Version int `json:"version"`
+	// DeletedAt и DeletedBy — когда и кем удалена запись. До окончательной очистки её можно восстановить
+	DeletedAt *time.Time `json:"-"`
+	DeletedBy *int       `json:"-"`
+}
+
+// LegacyDate возвращает значение устаревшего поля date, как и Post.LegacyDate
+func (c Comment) LegacyDate() int64 {
+	return legacyDate(c.CreatedAt, c.EditedAt)
+}
+
+func legacyDate(createdAt time.Time, editedAt *time.Time) int64 {
+	if editedAt != nil {
+		return editedAt.Unix()
+	}
+	return createdAt.Unix()
 }
 
 type Like struct {
@@ -31,3 +118,118 @@ type Like struct {
 	PostID    *int `json:"post_id,omitempty"`
 	CommentID *int `json:"comment_id,omitempty"`
 }
+
+// RefreshToken хранит хеш выданного refresh-токена. Токены одного входа образуют семейство (FamilyID)
+type RefreshToken struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"user_id"`
+	FamilyID  string     `json:"-"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// RevokedToken — отозванный до истечения срока access-токен
+type RevokedToken struct {
+	JTI       string    `gorm:"column:jti;primaryKey"`
+	UserID    int       `gorm:"column:user_id"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+}
+
+// UserTokenRevocation — граница, до которой все выпущенные токены пользователя считаются отозванными
+type UserTokenRevocation struct {
+	UserID        int       `gorm:"primaryKey;autoIncrement:false"`
+	RevokedBefore time.Time `gorm:"column:revoked_before"`
+}
+
+// OneTimeToken — одноразовый токен из письма (подтверждение email и т.п.). Хранится только хеш
+type OneTimeToken struct {
+	ID        int        `gorm:"primaryKey"`
+	UserID    int        `gorm:"column:user_id"`
+	Purpose   string     `gorm:"column:purpose"`
+	TokenHash string     `gorm:"column:token_hash"`
+	ExpiresAt time.Time  `gorm:"column:expires_at"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+}
+
+// RecoveryCode — одноразовый код восстановления для входа без приложения-аутентификатора. Хранится только хеш
+type RecoveryCode struct {
+	ID        int        `gorm:"primaryKey"`
+	UserID    int        `gorm:"column:user_id"`
+	CodeHash  string     `gorm:"column:code_hash"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+}
+
+// AdminAction — запись журнала действий модераторов и администраторов над чужими данными
+type AdminAction struct {
+	ID         int       `json:"id" gorm:"primaryKey"`
+	ActorID    int       `json:"actor_id"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetID   int       `json:"target_id"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// APIKey — персональный ключ API для ботов и интеграций. Сам ключ показывается один раз, хранится только хеш
+type APIKey struct {
+	ID         int        `json:"id" gorm:"primaryKey"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     StringList `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Session — вход с конкретного устройства. Её идентификатор совпадает с семейством refresh-токенов
+type Session struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	UserID     int        `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+// Режимы удаления аккаунта
+const (
+	// DeletionAnonymize — посты и комментарии остаются, их автором становится пользователь-заглушка
+	DeletionAnonymize = "anonymize"
+	// DeletionErase — посты и комментарии удаляются вместе с аккаунтом
+	DeletionErase = "erase"
+)
+
+// Состояния задачи удаления аккаунта
+const (
+	DeletionScheduled = "scheduled"
+	DeletionRunning   = "running"
+	DeletionCompleted = "completed"
+	DeletionCanceled  = "canceled"
+)
+
+// AccountDeletion — фоновая задача удаления аккаунта. Запускается после окончания срока восстановления
+// и переживает сам аккаунт, чтобы по её идентификатору можно было узнать результат
+type AccountDeletion struct {
+	ID     string `json:"id" gorm:"primaryKey"`
+	UserID int    `json:"-"`
+	Mode   string `json:"mode"`
+	Status string `json:"status"`
+	// Total — число лайков, комментариев и постов пользователя, Processed — сколько из них уже обработано
+	Total      int64      `json:"total"`
+	Processed  int64      `json:"processed"`
+	Error      string     `json:"error,omitempty"`
+	RunAfter   time.Time  `json:"run_after"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
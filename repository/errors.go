@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+
+	gosqlite "github.com/glebarez/go-sqlite"
+	"github.com/jackc/pgx/v5/pgconn"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// constraintError приводит ошибку драйвера о нарушении ограничения к ConstraintError.
+// Остальные ошибки возвращаются без изменений
+func constraintError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Коды ошибок: https://www.postgresql.org/docs/current/errcodes-appendix.html
+		switch pgErr.Code {
+		case "23505":
+			return &ConstraintError{Err: ErrDuplicate, Constraint: pgErr.ConstraintName}
+		case "23503":
+			return &ConstraintError{Err: ErrForeignKey, Constraint: pgErr.ConstraintName}
+		case "23514":
+			return &ConstraintError{Err: ErrCheck, Constraint: pgErr.ConstraintName}
+		}
+		return err
+	}
+
+	var sqliteErr *gosqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return &ConstraintError{Err: ErrDuplicate, Constraint: sqliteConstraintName(sqliteErr.Error())}
+		case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+			return &ConstraintError{Err: ErrForeignKey}
+		case sqlite3.SQLITE_CONSTRAINT_CHECK:
+			return &ConstraintError{Err: ErrCheck, Constraint: sqliteConstraintName(sqliteErr.Error())}
+		}
+	}
+	return err
+}
+
+// sqliteConstraintName выделяет имя ограничения из текста ошибки SQLite. Имя есть в тексте для индексов
+// по выражениям ("UNIQUE constraint failed: index 'users_email_key'") и именованных CHECK
+// ("CHECK constraint failed: likes_one_target_check"). Для уникальных колонок SQLite сообщает только их список
+func sqliteConstraintName(message string) string {
+	if _, rest, found := strings.Cut(message, "index '"); found {
+		name, _, _ := strings.Cut(rest, "'")
+		return name
+	}
+	if _, rest, found := strings.Cut(message, "CHECK constraint failed: "); found {
+		name, _, _ := strings.Cut(rest, " ")
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,693 @@
+package repository
+
+import (
+	"apiForSN/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGorm создаёт репозитории поверх соединения GORM (Postgres или SQLite)
+func NewGorm(db *gorm.DB) Repositories {
+	registerUTCTimes(db)
+	return newGormRepositories(db)
+}
+
+func newGormRepositories(db *gorm.DB) Repositories {
+	return Repositories{
+		Users:            gormUsers{db},
+		Posts:            gormPosts{db},
+		Comments:         gormComments{db},
+		Likes:            gormLikes{db},
+		AdminActions:     gormAdminActions{db},
+		APIKeys:          gormAPIKeys{db},
+		RecoveryCodes:    gormRecoveryCodes{db},
+		OneTimeTokens:    gormOneTimeTokens{db},
+		RefreshTokens:    gormRefreshTokens{db},
+		Sessions:         gormSessions{db},
+		Revocations:      gormRevocations{db},
+		AccountDeletions: gormAccountDeletions{db},
+		transaction: func(fn func(tx Repositories) error) error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				return fn(newGormRepositories(tx))
+			})
+		},
+	}
+}
+
+// notFound приводит ошибку GORM об отсутствии записи к ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// updateFields сохраняет перечисленные поля записи вместе с updated_at
+func updateFields(db *gorm.DB, model interface{}, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return constraintError(db.Model(model).Select(append(fields, "updated_at")).Updates(model).Error)
+}
+
+// updateVersioned сохраняет поля записи, только если её версия не изменилась с момента чтения, и увеличивает версию.
+// version указывает на поле Version модели: при ошибке в нём остаётся прочитанное значение
+func updateVersioned(db *gorm.DB, model interface{}, version *int, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	expected := *version
+	*version = expected + 1
+	result := db.Model(model).Where("version = ?", expected).Select(append(fields, "updated_at", "version")).Updates(model)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = ErrVersionConflict
+	}
+	if result.Error != nil {
+		*version = expected
+	}
+	return constraintError(result.Error)
+}
+
+// Условия видимости записей: удалённые пользователи скрывают свои посты и комментарии,
+// удалённые посты — свои комментарии
+const (
+	sqlActiveUser     = "users.deleted_at IS NULL"
+	sqlVisiblePost    = "posts.deleted_at IS NULL AND EXISTS (SELECT 1 FROM users WHERE users.id = posts.user_id AND " + sqlActiveUser + ")"
+	sqlVisibleComment = "comments.deleted_at IS NULL" +
+		" AND EXISTS (SELECT 1 FROM users WHERE users.id = comments.user_id AND " + sqlActiveUser + ")" +
+		" AND EXISTS (SELECT 1 FROM posts WHERE posts.id = comments.post_id AND " + sqlVisiblePost + ")"
+)
+
+// softDelete помечает запись удалённой, restore снимает пометку. Обе возвращают ErrNotFound,
+// если записи в нужном состоянии нет. columns — дополнительные колонки, например deleted_by
+func softDelete(db *gorm.DB, model interface{}, id int, at time.Time, columns map[string]interface{}) error {
+	values := map[string]interface{}{"deleted_at": at}
+	for column, value := range columns {
+		values[column] = value
+	}
+	result := db.Model(model).Where("id = ? AND deleted_at IS NULL", id).UpdateColumns(values)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+func restore(db *gorm.DB, model interface{}, id int, columns ...string) error {
+	values := map[string]interface{}{"deleted_at": nil}
+	for _, column := range columns {
+		values[column] = nil
+	}
+	result := db.Model(model).Where("id = ? AND deleted_at IS NOT NULL", id).UpdateColumns(values)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+// reassign передаёт до limit записей пользователя fromUserID пользователю toUserID.
+// UPDATE с LIMIT поддерживают не все базы, поэтому строки выбираются подзапросом
+func reassign(db *gorm.DB, model interface{}, fromUserID, toUserID, limit int) (int64, error) {
+	ids := db.Model(model).Select("id").Where("user_id = ?", fromUserID).Order("id").Limit(limit)
+	result := db.Model(model).Where("id IN (?)", ids).UpdateColumn("user_id", toUserID)
+	return result.RowsAffected, result.Error
+}
+
+// countByUser возвращает число записей пользователя
+func countByUser(db *gorm.DB, model interface{}, userID int) (int64, error) {
+	var count int64
+	err := db.Model(model).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// purge окончательно удаляет записи, помеченные удалёнными раньше deletedBefore. Зависимые записи удаляет база (ON DELETE CASCADE)
+func purge(db *gorm.DB, model interface{}, deletedBefore time.Time) (int64, error) {
+	result := db.Where("deleted_at < ?", deletedBefore.UTC()).Delete(model)
+	return result.RowsAffected, result.Error
+}
+
+type gormUsers struct{ db *gorm.DB }
+
+func (r gormUsers) Create(user *models.User) error {
+	return constraintError(r.db.Create(user).Error)
+}
+
+func (r gormUsers) GetByID(id int) (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("id = ?", id).Where(sqlActiveUser).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r gormUsers) GetByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("LOWER(email) = LOWER(?)", email).Where(sqlActiveUser).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r gormUsers) EmailTaken(email string, exceptID int) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).Where("LOWER(email) = LOWER(?) AND id <> ?", email, exceptID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r gormUsers) Update(user *models.User, fields ...string) error {
+	user.UpdatedAt = time.Now()
+	return updateFields(r.db, user, fields)
+}
+
+func (r gormUsers) ReplacePassword(id int, oldHash, newHash string) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND password = ?", id, oldHash).
+		Updates(map[string]interface{}{"password": newHash, "updated_at": time.Now()})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r gormUsers) AdvanceTOTPStep(id int, step int64) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		UpdateColumn("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r gormUsers) SoftDelete(id int, at time.Time) error {
+	return softDelete(r.db, &models.User{}, id, at, nil)
+}
+
+func (r gormUsers) GetDeletedByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("LOWER(email) = LOWER(?) AND deleted_at IS NOT NULL", email).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r gormUsers) Restore(id int) error {
+	return restore(r.db, &models.User{}, id)
+}
+
+func (r gormUsers) Delete(id int) error {
+	return r.db.Delete(&models.User{}, id).Error
+}
+
+func (r gormUsers) Tombstone() (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("roles = ?", TombstoneRole).Order("id").First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+type gormPosts struct{ db *gorm.DB }
+
+func (r gormPosts) Create(post *models.Post) error {
+	// Нумерация версий начинается с 1, как у DEFAULT в схеме
+	if post.Version == 0 {
+		post.Version = 1
+	}
+	return constraintError(r.db.Create(post).Error)
+}
+
+func (r gormPosts) GetByID(id int) (*models.Post, error) {
+	var post models.Post
+	if err := r.db.Where("id = ?", id).Where(sqlVisiblePost).First(&post).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &post, nil
+}
+
+func (r gormPosts) LockByID(id int) (*models.Post, error) {
+	var post models.Post
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).Where(sqlVisiblePost).First(&post).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &post, nil
+}
+
+func (r gormPosts) Update(post *models.Post, fields ...string) error {
+	post.UpdatedAt = time.Now()
+	return updateVersioned(r.db, post, &post.Version, fields)
+}
+
+func (r gormPosts) SoftDelete(id, deletedBy int, at time.Time) error {
+	return softDelete(r.db, &models.Post{}, id, at, map[string]interface{}{"deleted_by": deletedBy})
+}
+
+func (r gormPosts) GetDeleted(id int) (*models.Post, error) {
+	var post models.Post
+	if err := r.db.Where("id = ? AND deleted_at IS NOT NULL", id).First(&post).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &post, nil
+}
+
+func (r gormPosts) Restore(id int) error {
+	return restore(r.db, &models.Post{}, id, "deleted_by")
+}
+
+func (r gormPosts) Purge(deletedBefore time.Time) (int64, error) {
+	return purge(r.db, &models.Post{}, deletedBefore)
+}
+
+func (r gormPosts) Delete(id int) error {
+	return r.db.Delete(&models.Post{}, id).Error
+}
+
+func (r gormPosts) CountByUser(userID int) (int64, error) {
+	return countByUser(r.db, &models.Post{}, userID)
+}
+
+func (r gormPosts) ListByUser(userID int, limit int) ([]models.Post, error) {
+	var posts []models.Post
+	err := r.db.Where("user_id = ?", userID).Order("id").Limit(limit).Find(&posts).Error
+	return posts, err
+}
+
+func (r gormPosts) Reassign(fromUserID, toUserID int, limit int) (int64, error) {
+	return reassign(r.db, &models.Post{}, fromUserID, toUserID, limit)
+}
+
+func (r gormPosts) AddLikes(id int, delta int) error {
+	return r.db.Model(&models.Post{}).Where("id = ?", id).UpdateColumn("likes", gorm.Expr("likes + ?", delta)).Error
+}
+
+func (r gormPosts) AddComments(id int, delta int) error {
+	return r.db.Model(&models.Post{}).Where("id = ?", id).UpdateColumn("comments", gorm.Expr("comments + ?", delta)).Error
+}
+
+// Фактические значения счётчиков поста и комментария
+const (
+	postLikesCount    = "(SELECT COUNT(*) FROM likes WHERE likes.post_id = posts.id)"
+	postCommentsCount = "(SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.deleted_at IS NULL)"
+	commentLikesCount = "(SELECT COUNT(*) FROM likes WHERE likes.comment_id = comments.id)"
+	postCountersDrift = "likes <> " + postLikesCount + " OR comments <> " + postCommentsCount
+	commentLikesDrift = "likes <> " + commentLikesCount
+)
+
+func (r gormPosts) RecountCounters(dryRun bool) (int64, error) {
+	return recountCounters(r.db, &models.Post{}, postCountersDrift, dryRun, map[string]interface{}{
+		"likes":    gorm.Expr(postLikesCount),
+		"comments": gorm.Expr(postCommentsCount),
+	})
+}
+
+type gormComments struct{ db *gorm.DB }
+
+func (r gormComments) Create(comment *models.Comment) error {
+	if comment.Version == 0 {
+		comment.Version = 1
+	}
+	return constraintError(r.db.Create(comment).Error)
+}
+
+func (r gormComments) GetByID(id int) (*models.Comment, error) {
+	var comment models.Comment
+	if err := r.db.Where("id = ?", id).Where(sqlVisibleComment).First(&comment).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &comment, nil
+}
+
+func (r gormComments) LockByID(id int) (*models.Comment, error) {
+	var comment models.Comment
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).Where(sqlVisibleComment).First(&comment).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &comment, nil
+}
+
+func (r gormComments) Update(comment *models.Comment, fields ...string) error {
+	comment.UpdatedAt = time.Now()
+	return updateVersioned(r.db, comment, &comment.Version, fields)
+}
+
+func (r gormComments) SoftDelete(id, deletedBy int, at time.Time) error {
+	return softDelete(r.db, &models.Comment{}, id, at, map[string]interface{}{"deleted_by": deletedBy})
+}
+
+func (r gormComments) GetDeleted(id int) (*models.Comment, error) {
+	var comment models.Comment
+	if err := r.db.Where("id = ? AND deleted_at IS NOT NULL", id).First(&comment).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &comment, nil
+}
+
+func (r gormComments) Restore(id int) error {
+	return restore(r.db, &models.Comment{}, id, "deleted_by")
+}
+
+func (r gormComments) Purge(deletedBefore time.Time) (int64, error) {
+	return purge(r.db, &models.Comment{}, deletedBefore)
+}
+
+func (r gormComments) Delete(id int) error {
+	return r.db.Delete(&models.Comment{}, id).Error
+}
+
+func (r gormComments) CountByUser(userID int) (int64, error) {
+	return countByUser(r.db, &models.Comment{}, userID)
+}
+
+func (r gormComments) ListByUser(userID int, limit int) ([]models.Comment, error) {
+	var comments []models.Comment
+	err := r.db.Where("user_id = ?", userID).Order("id").Limit(limit).Find(&comments).Error
+	return comments, err
+}
+
+func (r gormComments) Reassign(fromUserID, toUserID int, limit int) (int64, error) {
+	return reassign(r.db, &models.Comment{}, fromUserID, toUserID, limit)
+}
+
+func (r gormComments) AddLikes(id int, delta int) error {
+	return r.db.Model(&models.Comment{}).Where("id = ?", id).UpdateColumn("likes", gorm.Expr("likes + ?", delta)).Error
+}
+
+func (r gormComments) RecountCounters(dryRun bool) (int64, error) {
+	return recountCounters(r.db, &models.Comment{}, commentLikesDrift, dryRun, map[string]interface{}{
+		"likes": gorm.Expr(commentLikesCount),
+	})
+}
+
+// recountCounters находит строки model, для которых выполняется условие drift, и записывает в них counters.
+// Строки сначала блокируются: в Postgres следующий запрос видит лайки и комментарии, добавленные
+// транзакциями, которые держали эти строки, и не затирает их свежие счётчики
+func recountCounters(db *gorm.DB, model interface{}, drift string, dryRun bool, counters map[string]interface{}) (int64, error) {
+	if dryRun {
+		var count int64
+		err := db.Model(model).Where(drift).Count(&count).Error
+		return count, err
+	}
+
+	var ids []int
+	if err := db.Model(model).Clauses(clause.Locking{Strength: "UPDATE"}).Where(drift).Order("id").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := db.Model(model).Where("id IN ?", ids).Where(drift).UpdateColumns(counters)
+	return result.RowsAffected, result.Error
+}
+
+type gormLikes struct{ db *gorm.DB }
+
+func (r gormLikes) FindPostLike(userID, postID int) (*models.Like, error) {
+	var like models.Like
+	if err := r.db.Where("user_id = ? AND post_id = ?", userID, postID).First(&like).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &like, nil
+}
+
+func (r gormLikes) FindCommentLike(userID, commentID int) (*models.Like, error) {
+	var like models.Like
+	if err := r.db.Where("user_id = ? AND comment_id = ?", userID, commentID).First(&like).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &like, nil
+}
+
+func (r gormLikes) Create(like *models.Like) error {
+	return constraintError(r.db.Create(like).Error)
+}
+
+func (r gormLikes) Delete(id int) error {
+	return r.db.Delete(&models.Like{}, id).Error
+}
+
+func (r gormLikes) CountByUser(userID int) (int64, error) {
+	return countByUser(r.db, &models.Like{}, userID)
+}
+
+func (r gormLikes) ListByUser(userID int, limit int) ([]models.Like, error) {
+	var likes []models.Like
+	err := r.db.Where("user_id = ?", userID).Order("id").Limit(limit).Find(&likes).Error
+	return likes, err
+}
+
+type gormAdminActions struct{ db *gorm.DB }
+
+func (r gormAdminActions) Create(action *models.AdminAction) error {
+	return constraintError(r.db.Create(action).Error)
+}
+
+func (r gormAdminActions) List(limit int) ([]models.AdminAction, error) {
+	var actions []models.AdminAction
+	err := r.db.Order("id DESC").Limit(limit).Find(&actions).Error
+	return actions, err
+}
+
+type gormAPIKeys struct{ db *gorm.DB }
+
+func (r gormAPIKeys) Create(key *models.APIKey) error {
+	return constraintError(r.db.Create(key).Error)
+}
+
+func (r gormAPIKeys) CountActive(userID int) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r gormAPIKeys) ListActive(userID int) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (r gormAPIKeys) Revoke(id, userID int, at time.Time) (bool, error) {
+	result := r.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r gormAPIKeys) GetByHash(hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &key, nil
+}
+
+func (r gormAPIKeys) TouchLastUsed(id int, at time.Time) error {
+	return r.db.Model(&models.APIKey{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error
+}
+
+type gormRecoveryCodes struct{ db *gorm.DB }
+
+func (r gormRecoveryCodes) Replace(userID int, codes []models.RecoveryCode) error {
+	if err := r.db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return constraintError(r.db.Create(&codes).Error)
+}
+
+func (r gormRecoveryCodes) ListUnused(userID int) ([]models.RecoveryCode, error) {
+	var codes []models.RecoveryCode
+	err := r.db.Where("user_id = ? AND used_at IS NULL", userID).Find(&codes).Error
+	return codes, err
+}
+
+func (r gormRecoveryCodes) MarkUsed(id int, at time.Time) (bool, error) {
+	result := r.db.Model(&models.RecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+type gormOneTimeTokens struct{ db *gorm.DB }
+
+func (r gormOneTimeTokens) Create(token *models.OneTimeToken) error {
+	return constraintError(r.db.Create(token).Error)
+}
+
+func (r gormOneTimeTokens) LockByHash(hash, purpose string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND purpose = ?", hash, purpose).
+		First(&token).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &token, nil
+}
+
+func (r gormOneTimeTokens) InvalidateUnused(userID int, purpose string, at time.Time) error {
+	return r.db.Model(&models.OneTimeToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", at).Error
+}
+
+type gormRefreshTokens struct{ db *gorm.DB }
+
+func (r gormRefreshTokens) Create(token *models.RefreshToken) error {
+	return constraintError(r.db.Create(token).Error)
+}
+
+func (r gormRefreshTokens) LockByHash(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &token, nil
+}
+
+func (r gormRefreshTokens) GetByHash(hash string, userID int) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := r.db.Where("token_hash = ? AND user_id = ?", hash, userID).First(&token).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &token, nil
+}
+
+func (r gormRefreshTokens) MarkUsed(id int, at time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).Where("id = ?", id).Update("used_at", at).Error
+}
+
+func (r gormRefreshTokens) RevokeFamily(familyID string, at time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", at).Error
+}
+
+func (r gormRefreshTokens) RevokeUser(userID int, at time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", at).Error
+}
+
+type gormSessions struct{ db *gorm.DB }
+
+func (r gormSessions) Create(session *models.Session) error {
+	return constraintError(r.db.Create(session).Error)
+}
+
+func (r gormSessions) GetActive(id string, userID int) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).First(&session).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &session, nil
+}
+
+func (r gormSessions) ListActive(userID int, seenAfter time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND last_seen_at > ?", userID, seenAfter.UTC()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (r gormSessions) Touch(id, userAgent, ip string, at time.Time) error {
+	return r.db.Model(&models.Session{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"user_agent":   userAgent,
+		"ip":           ip,
+		"last_seen_at": at,
+	}).Error
+}
+
+func (r gormSessions) UpdateLastSeen(id string, at time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ? AND last_seen_at < ?", id, at.UTC()).
+		UpdateColumn("last_seen_at", at).Error
+}
+
+func (r gormSessions) Revoke(id string, at time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at).Error
+}
+
+func (r gormSessions) RevokeUser(userID int, at time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", at).Error
+}
+
+type gormRevocations struct{ db *gorm.DB }
+
+func (r gormRevocations) RevokeToken(token models.RevokedToken) error {
+	// Заодно удаляем записи о токенах, которые уже истекли сами по себе
+	if err := r.db.Where("expires_at < ?", time.Now().UTC()).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return constraintError(r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&token).Error)
+}
+
+func (r gormRevocations) RevokeUserTokens(userID int, before time.Time) error {
+	record := models.UserTokenRevocation{UserID: userID, RevokedBefore: before}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before"}),
+	}).Create(&record).Error
+}
+
+func (r gormRevocations) IsRevoked(jti string, userID int, issuedAt time.Time, sessionID string) (bool, error) {
+	// Одним запросом проверяем отзыв конкретного токена, сессии, к которой привязан токен,
+	// а для токенов без сессии — отзыв всех токенов пользователя
+	var revoked bool
+	err := r.db.Raw(`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+		OR (? = '' AND EXISTS (SELECT 1 FROM user_token_revocations WHERE user_id = ? AND revoked_before >= ?))
+		OR EXISTS (SELECT 1 FROM sessions WHERE id = ? AND revoked_at IS NOT NULL)`,
+		jti, sessionID, userID, issuedAt.UTC(), sessionID).Scan(&revoked).Error
+	return revoked, err
+}
+
+type gormAccountDeletions struct{ db *gorm.DB }
+
+func (r gormAccountDeletions) Create(deletion *models.AccountDeletion) error {
+	return constraintError(r.db.Create(deletion).Error)
+}
+
+func (r gormAccountDeletions) ListUnscheduledUsers(limit int) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Where("deleted_at IS NOT NULL").
+		Where("NOT EXISTS (SELECT 1 FROM account_deletions WHERE account_deletions.user_id = users.id AND account_deletions.status IN ?)",
+			[]string{models.DeletionScheduled, models.DeletionRunning}).
+		Order("id").Limit(limit).Find(&users).Error
+	return users, err
+}
+
+func (r gormAccountDeletions) GetByID(id string) (*models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	if err := r.db.Where("id = ?", id).First(&deletion).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &deletion, nil
+}
+
+func (r gormAccountDeletions) LockDue(now, staleBefore time.Time) (*models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("(status = ? AND run_after <= ?) OR (status = ? AND updated_at < ?)",
+			models.DeletionScheduled, now.UTC(), models.DeletionRunning, staleBefore.UTC()).
+		Order("run_after").First(&deletion).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &deletion, nil
+}
+
+func (r gormAccountDeletions) Update(deletion *models.AccountDeletion) error {
+	deletion.UpdatedAt = time.Now()
+	return r.db.Save(deletion).Error
+}
+
+func (r gormAccountDeletions) CancelScheduled(userID int, at time.Time) error {
+	result := r.db.Model(&models.AccountDeletion{}).
+		Where("user_id = ? AND status = ?", userID, models.DeletionScheduled).
+		Updates(map[string]interface{}{"status": models.DeletionCanceled, "finished_at": at, "updated_at": at})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
@@ -0,0 +1,1521 @@
+package repository
+
+import (
+	"apiForSN/models"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemory создаёт пустое хранилище в памяти для тестов и локальной разработки.
+// Уникальность, внешние ключи и каскадное удаление работают так же, как в схеме базы; данные теряются при остановке процесса
+func NewMemory() Repositories {
+	state := newMemoryState()
+	// Пользователь-заглушка создаётся сразу, как миграцией 0005_account_deletions
+	now := time.Now()
+	tombstone := models.User{
+		ID:        state.nextID("users"),
+		Nickname:  TombstoneNickname,
+		Email:     TombstoneEmail,
+		Roles:     models.StringList{TombstoneRole},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	state.users[tombstone.ID] = tombstone
+	return newMemoryRepositories(&memoryDB{mu: &sync.Mutex{}, state: state})
+}
+
+// memoryState — содержимое хранилища. Записи хранятся по значению, наружу отдаются копии
+type memoryState struct {
+	lastID           map[string]int
+	users            map[int]models.User
+	posts            map[int]models.Post
+	comments         map[int]models.Comment
+	likes            map[int]models.Like
+	adminActions     map[int]models.AdminAction
+	apiKeys          map[int]models.APIKey
+	recoveryCodes    map[int]models.RecoveryCode
+	oneTimeTokens    map[int]models.OneTimeToken
+	refreshTokens    map[int]models.RefreshToken
+	sessions         map[string]models.Session
+	revokedTokens    map[string]models.RevokedToken
+	userRevocations  map[int]time.Time
+	accountDeletions map[string]models.AccountDeletion
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{
+		lastID:           make(map[string]int),
+		users:            make(map[int]models.User),
+		posts:            make(map[int]models.Post),
+		comments:         make(map[int]models.Comment),
+		likes:            make(map[int]models.Like),
+		adminActions:     make(map[int]models.AdminAction),
+		apiKeys:          make(map[int]models.APIKey),
+		recoveryCodes:    make(map[int]models.RecoveryCode),
+		oneTimeTokens:    make(map[int]models.OneTimeToken),
+		refreshTokens:    make(map[int]models.RefreshToken),
+		sessions:         make(map[string]models.Session),
+		revokedTokens:    make(map[string]models.RevokedToken),
+		userRevocations:  make(map[int]time.Time),
+		accountDeletions: make(map[string]models.AccountDeletion),
+	}
+}
+
+// nextID выдаёт следующий идентификатор таблицы, как последовательность SERIAL
+func (s *memoryState) nextID(table string) int {
+	s.lastID[table]++
+	return s.lastID[table]
+}
+
+// clone копирует состояние для отката транзакции. Записи не изменяются на месте
+// (списки внутри них копируются при записи), поэтому достаточно скопировать карты
+func (s *memoryState) clone() *memoryState {
+	return &memoryState{
+		lastID:           cloneMap(s.lastID),
+		users:            cloneMap(s.users),
+		posts:            cloneMap(s.posts),
+		comments:         cloneMap(s.comments),
+		likes:            cloneMap(s.likes),
+		adminActions:     cloneMap(s.adminActions),
+		apiKeys:          cloneMap(s.apiKeys),
+		recoveryCodes:    cloneMap(s.recoveryCodes),
+		oneTimeTokens:    cloneMap(s.oneTimeTokens),
+		refreshTokens:    cloneMap(s.refreshTokens),
+		sessions:         cloneMap(s.sessions),
+		revokedTokens:    cloneMap(s.revokedTokens),
+		userRevocations:  cloneMap(s.userRevocations),
+		accountDeletions: cloneMap(s.accountDeletions),
+	}
+}
+
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	copied := make(map[K]V, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func cloneList(list models.StringList) models.StringList {
+	if list == nil {
+		return nil
+	}
+	return append(models.StringList{}, list...)
+}
+
+// memoryDB — доступ к состоянию. Вне транзакции каждая операция берёт блокировку,
+// транзакция держит её целиком, поэтому транзакции выполняются строго по очереди
+type memoryDB struct {
+	mu    *sync.Mutex
+	state *memoryState
+	inTx  bool
+}
+
+func (m *memoryDB) run(fn func(s *memoryState) error) error {
+	if !m.inTx {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+	return fn(m.state)
+}
+
+func newMemoryRepositories(m *memoryDB) Repositories {
+	return Repositories{
+		Users:            memoryUsers{m},
+		Posts:            memoryPosts{m},
+		Comments:         memoryComments{m},
+		Likes:            memoryLikes{m},
+		AdminActions:     memoryAdminActions{m},
+		APIKeys:          memoryAPIKeys{m},
+		RecoveryCodes:    memoryRecoveryCodes{m},
+		OneTimeTokens:    memoryOneTimeTokens{m},
+		RefreshTokens:    memoryRefreshTokens{m},
+		Sessions:         memorySessions{m},
+		Revocations:      memoryRevocations{m},
+		AccountDeletions: memoryAccountDeletions{m},
+		transaction: func(fn func(tx Repositories) error) error {
+			tx := m
+			if !m.inTx {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				tx = &memoryDB{mu: m.mu, state: m.state, inTx: true}
+			}
+
+			// Вложенная транзакция откатывает только свои изменения, как SAVEPOINT
+			snapshot := m.state.clone()
+			committed := false
+			defer func() {
+				if !committed {
+					*m.state = *snapshot
+				}
+			}()
+			if err := fn(newMemoryRepositories(tx)); err != nil {
+				return err
+			}
+			committed = true
+			return nil
+		},
+	}
+}
+
+type memoryUsers struct{ m *memoryDB }
+
+func (r memoryUsers) Create(user *models.User) error {
+	return r.m.run(func(s *memoryState) error {
+		if err := checkUserUnique(s, *user); err != nil {
+			return err
+		}
+		now := time.Now()
+		user.ID = s.nextID("users")
+		if user.CreatedAt.IsZero() {
+			user.CreatedAt = now
+		}
+		if user.UpdatedAt.IsZero() {
+			user.UpdatedAt = now
+		}
+		stored := *user
+		stored.Roles = cloneList(user.Roles)
+		s.users[user.ID] = stored
+		return nil
+	})
+}
+
+func (r memoryUsers) GetByID(id int) (*models.User, error) {
+	var user models.User
+	err := r.m.run(func(s *memoryState) error {
+		stored, ok := s.users[id]
+		if !ok || stored.DeletedAt != nil {
+			return ErrNotFound
+		}
+		user = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.Roles = cloneList(user.Roles)
+	return &user, nil
+}
+
+func (r memoryUsers) GetByEmail(email string) (*models.User, error) {
+	var user *models.User
+	err := r.m.run(func(s *memoryState) error {
+		for _, stored := range s.users {
+			if stored.DeletedAt == nil && strings.EqualFold(stored.Email, email) {
+				stored.Roles = cloneList(stored.Roles)
+				user = &stored
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return user, err
+}
+
+// activeUser, visiblePost и visibleComment повторяют условия видимости sqlActiveUser, sqlVisiblePost и sqlVisibleComment:
+// удалённые пользователи скрывают свои посты и комментарии, удалённые посты — свои комментарии
+func activeUser(s *memoryState, id int) bool {
+	user, ok := s.users[id]
+	return ok && user.DeletedAt == nil
+}
+
+func visiblePost(s *memoryState, post models.Post) bool {
+	return post.DeletedAt == nil && activeUser(s, post.UserID)
+}
+
+func visibleComment(s *memoryState, comment models.Comment) bool {
+	post, ok := s.posts[comment.PostID]
+	return comment.DeletedAt == nil && activeUser(s, comment.UserID) && ok && visiblePost(s, post)
+}
+
+func (r memoryUsers) EmailTaken(email string, exceptID int) (bool, error) {
+	var taken bool
+	err := r.m.run(func(s *memoryState) error {
+		taken = emailTaken(s, email, exceptID)
+		return nil
+	})
+	return taken, err
+}
+
+func emailTaken(s *memoryState, email string, exceptID int) bool {
+	for _, user := range s.users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUserUnique повторяет уникальные индексы users по LOWER(email) и LOWER(nickname)
+func checkUserUnique(s *memoryState, user models.User) error {
+	for _, stored := range s.users {
+		if stored.ID == user.ID {
+			continue
+		}
+		if strings.EqualFold(stored.Email, user.Email) {
+			return &ConstraintError{Err: ErrDuplicate, Constraint: ConstraintUserEmail}
+		}
+		if strings.EqualFold(stored.Nickname, user.Nickname) {
+			return &ConstraintError{Err: ErrDuplicate, Constraint: ConstraintUserNickname}
+		}
+	}
+	return nil
+}
+
+func (r memoryUsers) Update(user *models.User, fields ...string) error {
+	user.UpdatedAt = time.Now()
+	if len(fields) == 0 {
+		return nil
+	}
+	return r.m.run(func(s *memoryState) error {
+		stored, ok := s.users[user.ID]
+		if !ok {
+			return nil
+		}
+		for _, field := range fields {
+			switch field {
+			case FieldNickname:
+				stored.Nickname = user.Nickname
+			case FieldEmail:
+				stored.Email = user.Email
+			case FieldPassword:
+				stored.Password = user.Password
+			case FieldEmailVerified:
+				stored.EmailVerified = user.EmailVerified
+			case FieldTOTPSecret:
+				stored.TOTPSecret = user.TOTPSecret
+			case FieldTOTPEnabled:
+				stored.TOTPEnabled = user.TOTPEnabled
+			case FieldTOTPLastStep:
+				stored.TOTPLastStep = user.TOTPLastStep
+			case FieldRoles:
+				stored.Roles = cloneList(user.Roles)
+			default:
+				return fmt.Errorf("unknown user field %q", field)
+			}
+		}
+		if err := checkUserUnique(s, stored); err != nil {
+			return err
+		}
+		stored.UpdatedAt = user.UpdatedAt
+		s.users[user.ID] = stored
+		return nil
+	})
+}
+
+func (r memoryUsers) ReplacePassword(id int, oldHash, newHash string) (bool, error) {
+	var replaced bool
+	err := r.m.run(func(s *memoryState) error {
+		stored, ok := s.users[id]
+		if !ok || stored.Password != oldHash {
+			return nil
+		}
+		stored.Password = newHash
+		stored.UpdatedAt = time.Now()
+		s.users[id] = stored
+		replaced = true
+		return nil
+	})
+	return replaced, err
+}
+
+func (r memoryUsers) AdvanceTOTPStep(id int, step int64) (bool, error) {
+	var advanced bool
+	err := r.m.run(func(s *memoryState) error {
+		stored, ok := s.users[id]
+		if !ok || stored.TOTPLastStep >= step {
+			return nil
+		}
+		stored.TOTPLastStep = step
+		s.users[id] = stored
+		advanced = true
+		return nil
+	})
+	return advanced, err
+}
+
+func (r memoryUsers) SoftDelete(id int, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		user, ok := s.users[id]
+		if !ok || user.DeletedAt != nil {
+			return ErrNotFound
+		}
+		user.DeletedAt = &at
+		s.users[id] = user
+		return nil
+	})
+}
+
+func (r memoryUsers) GetDeletedByEmail(email string) (*models.User, error) {
+	var user *models.User
+	err := r.m.run(func(s *memoryState) error {
+		for _, stored := range s.users {
+			if stored.DeletedAt != nil && strings.EqualFold(stored.Email, email) {
+				stored.Roles = cloneList(stored.Roles)
+				user = &stored
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return user, err
+}
+
+func (r memoryUsers) Restore(id int) error {
+	return r.m.run(func(s *memoryState) error {
+		user, ok := s.users[id]
+		if !ok || user.DeletedAt == nil {
+			return ErrNotFound
+		}
+		user.DeletedAt = nil
+		s.users[id] = user
+		return nil
+	})
+}
+
+func (r memoryUsers) Delete(id int) error {
+	return r.m.run(func(s *memoryState) error {
+		deleteUser(s, id)
+		return nil
+	})
+}
+
+func (r memoryUsers) Tombstone() (*models.User, error) {
+	var user models.User
+	err := r.m.run(func(s *memoryState) error {
+		found := false
+		for _, stored := range s.users {
+			if stored.Roles.Has(TombstoneRole) && (!found || stored.ID < user.ID) {
+				user, found = stored, true
+			}
+		}
+		if !found {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.Roles = cloneList(user.Roles)
+	return &user, nil
+}
+
+// deleteUser удаляет пользователя вместе со всем, что на него ссылается (ON DELETE CASCADE)
+func deleteUser(s *memoryState, id int) {
+	for postID, post := range s.posts {
+		if post.UserID == id {
+			deletePost(s, postID)
+		}
+	}
+	for commentID, comment := range s.comments {
+		if comment.UserID == id {
+			deleteComment(s, commentID)
+		}
+	}
+	deleteWhere(s.likes, func(like models.Like) bool { return like.UserID == id })
+	deleteWhere(s.refreshTokens, func(t models.RefreshToken) bool { return t.UserID == id })
+	deleteWhere(s.oneTimeTokens, func(t models.OneTimeToken) bool { return t.UserID == id })
+	deleteWhere(s.recoveryCodes, func(c models.RecoveryCode) bool { return c.UserID == id })
+	deleteWhere(s.apiKeys, func(k models.APIKey) bool { return k.UserID == id })
+	deleteWhere(s.sessions, func(session models.Session) bool { return session.UserID == id })
+	delete(s.users, id)
+}
+
+func deleteWhere[K comparable, V any](m map[K]V, match func(V) bool) {
+	for k, v := range m {
+		if match(v) {
+			delete(m, k)
+		}
+	}
+}
+
+type memoryPosts struct{ m *memoryDB }
+
+func (r memoryPosts) Create(post *models.Post) error {
+	return r.m.run(func(s *memoryState) error {
+		if _, ok := s.users[post.UserID]; !ok {
+			return ErrForeignKey
+		}
+		now := time.Now()
+		post.ID = s.nextID("posts")
+		if post.CreatedAt.IsZero() {
+			post.CreatedAt = now
+		}
+		if post.UpdatedAt.IsZero() {
+			post.UpdatedAt = now
+		}
+		if post.Version == 0 {
+			post.Version = 1
+		}
+		s.posts[post.ID] = *post
+		return nil
+	})
+}
+
+func (r memoryPosts) GetByID(id int) (*models.Post, error) {
+	var post models.Post
+	err := r.m.run(func(s *memoryState) error {
+		stored, ok := s.posts[id]
+		if !ok || !visiblePost(s, stored) {
+			return ErrNotFound
+		}
+		post = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// LockByID не берёт отдельной блокировки: транзакции в памяти и так выполняются по очереди
+func (r memoryPosts) LockByID(id int) (*models.Post, error) {
+	return r.GetByID(id)
+}
+
+func (r memoryPosts) Update(post *models.Post, fields ...string) error {
+	post.UpdatedAt = time.Now()
+	if len(fields) == 0 {
+		return nil
+	}
+	return r.m.run(func(s *memoryState) error {
+		stored, ok := s.posts[post.ID]
+		// Как в GORM: обновление, которое не затронуло ни одной строки, считается конфликтом версий
+		if !ok || stored.Version != post.Version {
+			return ErrVersionConflict
+		}
+		for _, field := range fields {
+			switch field {
+			case FieldContent:
+				stored.Content = post.Content
+			case FieldEditedAt:
+				stored.EditedAt = post.EditedAt
+			default:
+				return fmt.Errorf("unknown post field %q", field)
+			}
+		}
+		stored.UpdatedAt = post.UpdatedAt
+		stored.Version++
+		post.Version = stored.Version
+		s.posts[post.ID] = stored
+		return nil
+	})
+}
+
+func (r memoryPosts) SoftDelete(id, deletedBy int, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		post, ok := s.posts[id]
+		if !ok || post.DeletedAt != nil {
+			return ErrNotFound
+		}
+		post.DeletedAt, post.DeletedBy = &at, &deletedBy
+		s.posts[id] = post
+		return nil
+	})
+}
+
+func (r memoryPosts) GetDeleted(id int) (*models.Post, error) {
+	var post models.Post
+	err := r.m.run(func(s *memoryState) error {
+		stored, ok := s.posts[id]
+		if !ok || stored.DeletedAt == nil {
+			return ErrNotFound
+		}
+		post = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func (r memoryPosts) Restore(id int) error {
+	return r.m.run(func(s *memoryState) error {
+		post, ok := s.posts[id]
+		if !ok || post.DeletedAt == nil {
+			return ErrNotFound
+		}
+		post.DeletedAt, post.DeletedBy = nil, nil
+		s.posts[id] = post
+		return nil
+	})
+}
+
+func (r memoryPosts) Purge(deletedBefore time.Time) (int64, error) {
+	var purged int64
+	err := r.m.run(func(s *memoryState) error {
+		for id, post := range s.posts {
+			if post.DeletedAt != nil && post.DeletedAt.Before(deletedBefore) {
+				deletePost(s, id)
+				purged++
+			}
+		}
+		return nil
+	})
+	return purged, err
+}
+
+func (r memoryPosts) Delete(id int) error {
+	return r.m.run(func(s *memoryState) error {
+		deletePost(s, id)
+		return nil
+	})
+}
+
+func (r memoryPosts) CountByUser(userID int) (int64, error) {
+	posts, err := r.ListByUser(userID, -1)
+	return int64(len(posts)), err
+}
+
+func (r memoryPosts) ListByUser(userID int, limit int) ([]models.Post, error) {
+	var posts []models.Post
+	err := r.m.run(func(s *memoryState) error {
+		posts = postsByUser(s, userID, limit)
+		return nil
+	})
+	return posts, err
+}
+
+// postsByUser возвращает первые limit записей пользователя по ID, при отрицательном limit — все
+func postsByUser(s *memoryState, userID int, limit int) []models.Post {
+	var posts []models.Post
+	for _, post := range s.posts {
+		if post.UserID == userID {
+			posts = append(posts, post)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].ID < posts[j].ID })
+	if limit >= 0 && len(posts) > limit {
+		posts = posts[:limit]
+	}
+	return posts
+}
+
+func (r memoryPosts) Reassign(fromUserID, toUserID int, limit int) (int64, error) {
+	var reassigned int64
+	err := r.m.run(func(s *memoryState) error {
+		if _, ok := s.users[toUserID]; !ok {
+			return ErrForeignKey
+		}
+		for _, post := range postsByUser(s, fromUserID, limit) {
+			post.UserID = toUserID
+			s.posts[post.ID] = post
+			reassigned++
+		}
+		return nil
+	})
+	return reassigned, err
+}
+
+// deletePost удаляет пост вместе с его комментариями и лайками (ON DELETE CASCADE)
+func deletePost(s *memoryState, id int) {
+	for commentID, comment := range s.comments {
+		if comment.PostID == id {
+			deleteComment(s, commentID)
+		}
+	}
+	deleteWhere(s.likes, func(like models.Like) bool { return like.PostID != nil && *like.PostID == id })
+	delete(s.posts, id)
+}
+
+func (r memoryPosts) AddLikes(id int, delta int) error {
+	return r.m.run(func(s *memoryState) error {
+		if post, ok := s.posts[id]; ok {
+			post.Likes += delta
+			s.posts[id] = post
+		}
+		return nil
+	})
+}
+
+func (r memoryPosts) AddComments(id int, delta int) error {
+	return r.m.run(func(s *memoryState) error {
+		if post, ok := s.posts[id]; ok {
+			post.Comments += delta
+			s.posts[id] = post
+		}
+		return nil
+	})
+}
+
+func (r memoryPosts) RecountCounters(dryRun bool) (int64, error) {
+	var drifted int64
+	err := r.m.run(func(s *memoryState) error {
+		likes := make(map[int]int)
+		for _, like := range s.likes {
+			if like.PostID != nil {
+				likes[*like.PostID]++
+			}
+		}
+		comments := make(map[int]int)
+		for _, comment := range s.comments {
+			if comment.DeletedAt == nil {
+				comments[comment.PostID]++
+			}
+		}
+		for id, post := range s.posts {
+			if post.Likes == likes[id] && post.Comments == comments[id] {
+				continue
+			}
+			drifted++
+			if !dryRun {
+				post.Likes, post.Comments = likes[id], comments[id]
+				s.posts[id] = post
+			}
+		}
+		return nil
+	})
+	return drifted, err
+}
+
+type memoryComments struct{ m *memoryDB }
+
+func (r memoryComments) Create(comment *models.Comment) error {
+	return r.m.run(func(s *memoryState) error {
+		if _, ok := s.users[comment.UserID]; !ok {
+			return ErrForeignKey
+		}
+		if _, ok := s.posts[comment.PostID]; !ok {
+			return ErrForeignKey
+		}
+		now := time.Now()
+		comment.ID = s.nextID("comments")
+		if comment.CreatedAt.IsZero() {
+			comment.CreatedAt = now
+		}
+		if comment.UpdatedAt.IsZero() {
+			comment.UpdatedAt = now
+		}
+		if comment.Version == 0 {
+			comment.Version = 1
+		}
+		s.comments[comment.ID] = *comment
+		return nil
+	})
+}
+
+func (r memoryComments) GetByID(id int) (*models.Comment, error) {
+	var comment models.Comment
+	err := r.m.run(func(s *memoryState) error {
+		stored, ok := s.comments[id]
+		if !ok || !visibleComment(s, stored) {
+			return ErrNotFound
+		}
+		comment = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// LockByID не берёт отдельной блокировки, как и memoryPosts.LockByID
+func (r memoryComments) LockByID(id int) (*models.Comment, error) {
+	return r.GetByID(id)
+}
+
+func (r memoryComments) Update(comment *models.Comment, fields ...string) error {
+	comment.UpdatedAt = time.Now()
+	if len(fields) == 0 {
+		return nil
+	}
+	return r.m.run(func(s *memoryState) error {
+		stored, ok := s.comments[comment.ID]
+		if !ok || stored.Version != comment.Version {
+			return ErrVersionConflict
+		}
+		for _, field := range fields {
+			switch field {
+			case FieldContent:
+				stored.Content = comment.Content
+			case FieldEditedAt:
+				stored.EditedAt = comment.EditedAt
+			default:
+				return fmt.Errorf("unknown comment field %q", field)
+			}
+		}
+		stored.UpdatedAt = comment.UpdatedAt
+		stored.Version++
+		comment.Version = stored.Version
+		s.comments[comment.ID] = stored
+		return nil
+	})
+}
+
+func (r memoryComments) SoftDelete(id, deletedBy int, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		comment, ok := s.comments[id]
+		if !ok || comment.DeletedAt != nil {
+			return ErrNotFound
+		}
+		comment.DeletedAt, comment.DeletedBy = &at, &deletedBy
+		s.comments[id] = comment
+		return nil
+	})
+}
+
+func (r memoryComments) GetDeleted(id int) (*models.Comment, error) {
+	var comment models.Comment
+	err := r.m.run(func(s *memoryState) error {
+		stored, ok := s.comments[id]
+		if !ok || stored.DeletedAt == nil {
+			return ErrNotFound
+		}
+		comment = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+func (r memoryComments) Restore(id int) error {
+	return r.m.run(func(s *memoryState) error {
+		comment, ok := s.comments[id]
+		if !ok || comment.DeletedAt == nil {
+			return ErrNotFound
+		}
+		comment.DeletedAt, comment.DeletedBy = nil, nil
+		s.comments[id] = comment
+		return nil
+	})
+}
+
+func (r memoryComments) Purge(deletedBefore time.Time) (int64, error) {
+	var purged int64
+	err := r.m.run(func(s *memoryState) error {
+		for id, comment := range s.comments {
+			if comment.DeletedAt != nil && comment.DeletedAt.Before(deletedBefore) {
+				deleteComment(s, id)
+				purged++
+			}
+		}
+		return nil
+	})
+	return purged, err
+}
+
+func (r memoryComments) Delete(id int) error {
+	return r.m.run(func(s *memoryState) error {
+		deleteComment(s, id)
+		return nil
+	})
+}
+
+func (r memoryComments) CountByUser(userID int) (int64, error) {
+	comments, err := r.ListByUser(userID, -1)
+	return int64(len(comments)), err
+}
+
+func (r memoryComments) ListByUser(userID int, limit int) ([]models.Comment, error) {
+	var comments []models.Comment
+	err := r.m.run(func(s *memoryState) error {
+		comments = commentsByUser(s, userID, limit)
+		return nil
+	})
+	return comments, err
+}
+
+// commentsByUser работает как postsByUser
+func commentsByUser(s *memoryState, userID int, limit int) []models.Comment {
+	var comments []models.Comment
+	for _, comment := range s.comments {
+		if comment.UserID == userID {
+			comments = append(comments, comment)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].ID < comments[j].ID })
+	if limit >= 0 && len(comments) > limit {
+		comments = comments[:limit]
+	}
+	return comments
+}
+
+func (r memoryComments) Reassign(fromUserID, toUserID int, limit int) (int64, error) {
+	var reassigned int64
+	err := r.m.run(func(s *memoryState) error {
+		if _, ok := s.users[toUserID]; !ok {
+			return ErrForeignKey
+		}
+		for _, comment := range commentsByUser(s, fromUserID, limit) {
+			comment.UserID = toUserID
+			s.comments[comment.ID] = comment
+			reassigned++
+		}
+		return nil
+	})
+	return reassigned, err
+}
+
+// deleteComment удаляет комментарий вместе с его лайками (ON DELETE CASCADE)
+func deleteComment(s *memoryState, id int) {
+	deleteWhere(s.likes, func(like models.Like) bool { return like.CommentID != nil && *like.CommentID == id })
+	delete(s.comments, id)
+}
+
+func (r memoryComments) AddLikes(id int, delta int) error {
+	return r.m.run(func(s *memoryState) error {
+		if comment, ok := s.comments[id]; ok {
+			comment.Likes += delta
+			s.comments[id] = comment
+		}
+		return nil
+	})
+}
+
+func (r memoryComments) RecountCounters(dryRun bool) (int64, error) {
+	var drifted int64
+	err := r.m.run(func(s *memoryState) error {
+		likes := make(map[int]int)
+		for _, like := range s.likes {
+			if like.CommentID != nil {
+				likes[*like.CommentID]++
+			}
+		}
+		for id, comment := range s.comments {
+			if comment.Likes == likes[id] {
+				continue
+			}
+			drifted++
+			if !dryRun {
+				comment.Likes = likes[id]
+				s.comments[id] = comment
+			}
+		}
+		return nil
+	})
+	return drifted, err
+}
+
+type memoryLikes struct{ m *memoryDB }
+
+func (r memoryLikes) FindPostLike(userID, postID int) (*models.Like, error) {
+	return r.find(func(like models.Like) bool {
+		return like.UserID == userID && like.PostID != nil && *like.PostID == postID
+	})
+}
+
+func (r memoryLikes) FindCommentLike(userID, commentID int) (*models.Like, error) {
+	return r.find(func(like models.Like) bool {
+		return like.UserID == userID && like.CommentID != nil && *like.CommentID == commentID
+	})
+}
+
+func (r memoryLikes) find(match func(models.Like) bool) (*models.Like, error) {
+	var like *models.Like
+	err := r.m.run(func(s *memoryState) error {
+		for _, stored := range s.likes {
+			if match(stored) {
+				like = &stored
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return like, err
+}
+
+func (r memoryLikes) Create(like *models.Like) error {
+	return r.m.run(func(s *memoryState) error {
+		// Лайк относится либо к посту, либо к комментарию (likes_one_target_check)
+		if (like.PostID == nil) == (like.CommentID == nil) {
+			return &ConstraintError{Err: ErrCheck, Constraint: "likes_one_target_check"}
+		}
+		if _, ok := s.users[like.UserID]; !ok {
+			return ErrForeignKey
+		}
+		if like.PostID != nil {
+			if _, ok := s.posts[*like.PostID]; !ok {
+				return ErrForeignKey
+			}
+		}
+		if like.CommentID != nil {
+			if _, ok := s.comments[*like.CommentID]; !ok {
+				return ErrForeignKey
+			}
+		}
+		// Пользователь может поставить объекту только один лайк
+		for _, stored := range s.likes {
+			if stored.UserID == like.UserID && sameTarget(stored.PostID, like.PostID) && sameTarget(stored.CommentID, like.CommentID) {
+				return ErrDuplicate
+			}
+		}
+		like.ID = s.nextID("likes")
+		s.likes[like.ID] = *like
+		return nil
+	})
+}
+
+func sameTarget(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (r memoryLikes) Delete(id int) error {
+	return r.m.run(func(s *memoryState) error {
+		delete(s.likes, id)
+		return nil
+	})
+}
+
+func (r memoryLikes) CountByUser(userID int) (int64, error) {
+	likes, err := r.ListByUser(userID, -1)
+	return int64(len(likes)), err
+}
+
+func (r memoryLikes) ListByUser(userID int, limit int) ([]models.Like, error) {
+	var likes []models.Like
+	err := r.m.run(func(s *memoryState) error {
+		likes = likesByUser(s, userID, limit)
+		return nil
+	})
+	return likes, err
+}
+
+// likesByUser работает как postsByUser
+func likesByUser(s *memoryState, userID int, limit int) []models.Like {
+	var likes []models.Like
+	for _, like := range s.likes {
+		if like.UserID == userID {
+			likes = append(likes, like)
+		}
+	}
+	sort.Slice(likes, func(i, j int) bool { return likes[i].ID < likes[j].ID })
+	if limit >= 0 && len(likes) > limit {
+		likes = likes[:limit]
+	}
+	return likes
+}
+
+type memoryAdminActions struct{ m *memoryDB }
+
+func (r memoryAdminActions) Create(action *models.AdminAction) error {
+	return r.m.run(func(s *memoryState) error {
+		action.ID = s.nextID("admin_actions")
+		if action.CreatedAt.IsZero() {
+			action.CreatedAt = time.Now()
+		}
+		s.adminActions[action.ID] = *action
+		return nil
+	})
+}
+
+func (r memoryAdminActions) List(limit int) ([]models.AdminAction, error) {
+	var actions []models.AdminAction
+	err := r.m.run(func(s *memoryState) error {
+		for _, action := range s.adminActions {
+			actions = append(actions, action)
+		}
+		return nil
+	})
+	sort.Slice(actions, func(i, j int) bool { return actions[i].ID > actions[j].ID })
+	if limit >= 0 && len(actions) > limit {
+		actions = actions[:limit]
+	}
+	return actions, err
+}
+
+type memoryAPIKeys struct{ m *memoryDB }
+
+func (r memoryAPIKeys) Create(key *models.APIKey) error {
+	return r.m.run(func(s *memoryState) error {
+		if _, ok := s.users[key.UserID]; !ok {
+			return ErrForeignKey
+		}
+		for _, stored := range s.apiKeys {
+			if stored.KeyHash == key.KeyHash {
+				return ErrDuplicate
+			}
+		}
+		key.ID = s.nextID("api_keys")
+		if key.CreatedAt.IsZero() {
+			key.CreatedAt = time.Now()
+		}
+		stored := *key
+		stored.Scopes = cloneList(key.Scopes)
+		s.apiKeys[key.ID] = stored
+		return nil
+	})
+}
+
+func (r memoryAPIKeys) CountActive(userID int) (int64, error) {
+	keys, err := r.ListActive(userID)
+	return int64(len(keys)), err
+}
+
+func (r memoryAPIKeys) ListActive(userID int) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.m.run(func(s *memoryState) error {
+		for _, key := range s.apiKeys {
+			if key.UserID == userID && key.RevokedAt == nil {
+				key.Scopes = cloneList(key.Scopes)
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, err
+}
+
+func (r memoryAPIKeys) Revoke(id, userID int, at time.Time) (bool, error) {
+	var revoked bool
+	err := r.m.run(func(s *memoryState) error {
+		key, ok := s.apiKeys[id]
+		if !ok || key.UserID != userID || key.RevokedAt != nil {
+			return nil
+		}
+		key.RevokedAt = &at
+		s.apiKeys[id] = key
+		revoked = true
+		return nil
+	})
+	return revoked, err
+}
+
+func (r memoryAPIKeys) GetByHash(hash string) (*models.APIKey, error) {
+	var key *models.APIKey
+	err := r.m.run(func(s *memoryState) error {
+		for _, stored := range s.apiKeys {
+			if stored.KeyHash == hash {
+				stored.Scopes = cloneList(stored.Scopes)
+				key = &stored
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return key, err
+}
+
+func (r memoryAPIKeys) TouchLastUsed(id int, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		if key, ok := s.apiKeys[id]; ok {
+			key.LastUsedAt = &at
+			s.apiKeys[id] = key
+		}
+		return nil
+	})
+}
+
+type memoryRecoveryCodes struct{ m *memoryDB }
+
+func (r memoryRecoveryCodes) Replace(userID int, codes []models.RecoveryCode) error {
+	return r.m.run(func(s *memoryState) error {
+		if _, ok := s.users[userID]; !ok && len(codes) > 0 {
+			return ErrForeignKey
+		}
+		deleteWhere(s.recoveryCodes, func(c models.RecoveryCode) bool { return c.UserID == userID })
+		now := time.Now()
+		for i := range codes {
+			codes[i].ID = s.nextID("recovery_codes")
+			if codes[i].CreatedAt.IsZero() {
+				codes[i].CreatedAt = now
+			}
+			s.recoveryCodes[codes[i].ID] = codes[i]
+		}
+		return nil
+	})
+}
+
+func (r memoryRecoveryCodes) ListUnused(userID int) ([]models.RecoveryCode, error) {
+	var codes []models.RecoveryCode
+	err := r.m.run(func(s *memoryState) error {
+		for _, code := range s.recoveryCodes {
+			if code.UserID == userID && code.UsedAt == nil {
+				codes = append(codes, code)
+			}
+		}
+		return nil
+	})
+	sort.Slice(codes, func(i, j int) bool { return codes[i].ID < codes[j].ID })
+	return codes, err
+}
+
+func (r memoryRecoveryCodes) MarkUsed(id int, at time.Time) (bool, error) {
+	var marked bool
+	err := r.m.run(func(s *memoryState) error {
+		code, ok := s.recoveryCodes[id]
+		if !ok || code.UsedAt != nil {
+			return nil
+		}
+		code.UsedAt = &at
+		s.recoveryCodes[id] = code
+		marked = true
+		return nil
+	})
+	return marked, err
+}
+
+type memoryOneTimeTokens struct{ m *memoryDB }
+
+func (r memoryOneTimeTokens) Create(token *models.OneTimeToken) error {
+	return r.m.run(func(s *memoryState) error {
+		if _, ok := s.users[token.UserID]; !ok {
+			return ErrForeignKey
+		}
+		for _, stored := range s.oneTimeTokens {
+			if stored.TokenHash == token.TokenHash {
+				return ErrDuplicate
+			}
+		}
+		token.ID = s.nextID("one_time_tokens")
+		if token.CreatedAt.IsZero() {
+			token.CreatedAt = time.Now()
+		}
+		s.oneTimeTokens[token.ID] = *token
+		return nil
+	})
+}
+
+// LockByHash не берёт отдельной блокировки: транзакции в памяти и так выполняются по очереди
+func (r memoryOneTimeTokens) LockByHash(hash, purpose string) (*models.OneTimeToken, error) {
+	var token *models.OneTimeToken
+	err := r.m.run(func(s *memoryState) error {
+		for _, stored := range s.oneTimeTokens {
+			if stored.TokenHash == hash && stored.Purpose == purpose {
+				token = &stored
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return token, err
+}
+
+func (r memoryOneTimeTokens) InvalidateUnused(userID int, purpose string, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		for id, token := range s.oneTimeTokens {
+			if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+				token.UsedAt = &at
+				s.oneTimeTokens[id] = token
+			}
+		}
+		return nil
+	})
+}
+
+type memoryRefreshTokens struct{ m *memoryDB }
+
+func (r memoryRefreshTokens) Create(token *models.RefreshToken) error {
+	return r.m.run(func(s *memoryState) error {
+		if _, ok := s.users[token.UserID]; !ok {
+			return ErrForeignKey
+		}
+		for _, stored := range s.refreshTokens {
+			if stored.TokenHash == token.TokenHash {
+				return ErrDuplicate
+			}
+		}
+		token.ID = s.nextID("refresh_tokens")
+		if token.CreatedAt.IsZero() {
+			token.CreatedAt = time.Now()
+		}
+		s.refreshTokens[token.ID] = *token
+		return nil
+	})
+}
+
+// LockByHash не берёт отдельной блокировки: транзакции в памяти и так выполняются по очереди
+func (r memoryRefreshTokens) LockByHash(hash string) (*models.RefreshToken, error) {
+	return r.find(func(token models.RefreshToken) bool { return token.TokenHash == hash })
+}
+
+func (r memoryRefreshTokens) GetByHash(hash string, userID int) (*models.RefreshToken, error) {
+	return r.find(func(token models.RefreshToken) bool { return token.TokenHash == hash && token.UserID == userID })
+}
+
+func (r memoryRefreshTokens) find(match func(models.RefreshToken) bool) (*models.RefreshToken, error) {
+	var token *models.RefreshToken
+	err := r.m.run(func(s *memoryState) error {
+		for _, stored := range s.refreshTokens {
+			if match(stored) {
+				token = &stored
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return token, err
+}
+
+func (r memoryRefreshTokens) MarkUsed(id int, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		if token, ok := s.refreshTokens[id]; ok {
+			token.UsedAt = &at
+			s.refreshTokens[id] = token
+		}
+		return nil
+	})
+}
+
+func (r memoryRefreshTokens) RevokeFamily(familyID string, at time.Time) error {
+	return r.revokeWhere(func(token models.RefreshToken) bool { return token.FamilyID == familyID }, at)
+}
+
+func (r memoryRefreshTokens) RevokeUser(userID int, at time.Time) error {
+	return r.revokeWhere(func(token models.RefreshToken) bool { return token.UserID == userID }, at)
+}
+
+func (r memoryRefreshTokens) revokeWhere(match func(models.RefreshToken) bool, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		for id, token := range s.refreshTokens {
+			if token.RevokedAt == nil && match(token) {
+				token.RevokedAt = &at
+				s.refreshTokens[id] = token
+			}
+		}
+		return nil
+	})
+}
+
+type memorySessions struct{ m *memoryDB }
+
+func (r memorySessions) Create(session *models.Session) error {
+	return r.m.run(func(s *memoryState) error {
+		if _, ok := s.users[session.UserID]; !ok {
+			return ErrForeignKey
+		}
+		if _, ok := s.sessions[session.ID]; ok {
+			return ErrDuplicate
+		}
+		s.sessions[session.ID] = *session
+		return nil
+	})
+}
+
+func (r memorySessions) GetActive(id string, userID int) (*models.Session, error) {
+	var session models.Session
+	err := r.m.run(func(s *memoryState) error {
+		stored, ok := s.sessions[id]
+		if !ok || stored.UserID != userID || stored.RevokedAt != nil {
+			return ErrNotFound
+		}
+		session = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r memorySessions) ListActive(userID int, seenAfter time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.m.run(func(s *memoryState) error {
+		for _, session := range s.sessions {
+			if session.UserID == userID && session.RevokedAt == nil && session.LastSeenAt.After(seenAfter) {
+				sessions = append(sessions, session)
+			}
+		}
+		return nil
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, err
+}
+
+func (r memorySessions) Touch(id, userAgent, ip string, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		if session, ok := s.sessions[id]; ok {
+			session.UserAgent, session.IP, session.LastSeenAt = userAgent, ip, at
+			s.sessions[id] = session
+		}
+		return nil
+	})
+}
+
+func (r memorySessions) UpdateLastSeen(id string, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		if session, ok := s.sessions[id]; ok && session.LastSeenAt.Before(at) {
+			session.LastSeenAt = at
+			s.sessions[id] = session
+		}
+		return nil
+	})
+}
+
+func (r memorySessions) Revoke(id string, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		if session, ok := s.sessions[id]; ok && session.RevokedAt == nil {
+			session.RevokedAt = &at
+			s.sessions[id] = session
+		}
+		return nil
+	})
+}
+
+func (r memorySessions) RevokeUser(userID int, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		for id, session := range s.sessions {
+			if session.UserID == userID && session.RevokedAt == nil {
+				session.RevokedAt = &at
+				s.sessions[id] = session
+			}
+		}
+		return nil
+	})
+}
+
+type memoryRevocations struct{ m *memoryDB }
+
+func (r memoryRevocations) RevokeToken(token models.RevokedToken) error {
+	return r.m.run(func(s *memoryState) error {
+		now := time.Now()
+		deleteWhere(s.revokedTokens, func(t models.RevokedToken) bool { return t.ExpiresAt.Before(now) })
+		if _, ok := s.revokedTokens[token.JTI]; !ok {
+			s.revokedTokens[token.JTI] = token
+		}
+		return nil
+	})
+}
+
+func (r memoryRevocations) RevokeUserTokens(userID int, before time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		s.userRevocations[userID] = before
+		return nil
+	})
+}
+
+func (r memoryRevocations) IsRevoked(jti string, userID int, issuedAt time.Time, sessionID string) (bool, error) {
+	var revoked bool
+	err := r.m.run(func(s *memoryState) error {
+		_, tokenRevoked := s.revokedTokens[jti]
+		before, userRevoked := s.userRevocations[userID]
+		session, sessionExists := s.sessions[sessionID]
+		revoked = tokenRevoked ||
+			(sessionID == "" && userRevoked && !before.Before(issuedAt)) ||
+			(sessionExists && session.RevokedAt != nil)
+		return nil
+	})
+	return revoked, err
+}
+
+type memoryAccountDeletions struct{ m *memoryDB }
+
+func (r memoryAccountDeletions) Create(deletion *models.AccountDeletion) error {
+	return r.m.run(func(s *memoryState) error {
+		if _, ok := s.accountDeletions[deletion.ID]; ok {
+			return ErrDuplicate
+		}
+		// Как account_deletions_active_user_key: не больше одной незавершённой задачи на пользователя
+		if activeDeletion(deletion) {
+			for _, stored := range s.accountDeletions {
+				if stored.UserID == deletion.UserID && activeDeletion(&stored) {
+					return &ConstraintError{Err: ErrDuplicate, Constraint: "account_deletions_active_user_key"}
+				}
+			}
+		}
+		now := time.Now()
+		if deletion.CreatedAt.IsZero() {
+			deletion.CreatedAt = now
+		}
+		if deletion.UpdatedAt.IsZero() {
+			deletion.UpdatedAt = now
+		}
+		s.accountDeletions[deletion.ID] = *deletion
+		return nil
+	})
+}
+
+// activeDeletion сообщает, что задача ещё не завершена и не отменена
+func activeDeletion(deletion *models.AccountDeletion) bool {
+	return deletion.Status == models.DeletionScheduled || deletion.Status == models.DeletionRunning
+}
+
+func (r memoryAccountDeletions) ListUnscheduledUsers(limit int) ([]models.User, error) {
+	var users []models.User
+	err := r.m.run(func(s *memoryState) error {
+		scheduled := make(map[int]bool)
+		for _, deletion := range s.accountDeletions {
+			if activeDeletion(&deletion) {
+				scheduled[deletion.UserID] = true
+			}
+		}
+		for _, user := range s.users {
+			if user.DeletedAt != nil && !scheduled[user.ID] {
+				user.Roles = cloneList(user.Roles)
+				users = append(users, user)
+			}
+		}
+		return nil
+	})
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if limit >= 0 && len(users) > limit {
+		users = users[:limit]
+	}
+	return users, err
+}
+
+func (r memoryAccountDeletions) GetByID(id string) (*models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	err := r.m.run(func(s *memoryState) error {
+		stored, ok := s.accountDeletions[id]
+		if !ok {
+			return ErrNotFound
+		}
+		deletion = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+// LockDue не берёт отдельной блокировки, как и memoryPosts.LockByID
+func (r memoryAccountDeletions) LockDue(now, staleBefore time.Time) (*models.AccountDeletion, error) {
+	var due *models.AccountDeletion
+	err := r.m.run(func(s *memoryState) error {
+		for _, stored := range s.accountDeletions {
+			ready := (stored.Status == models.DeletionScheduled && !stored.RunAfter.After(now)) ||
+				(stored.Status == models.DeletionRunning && stored.UpdatedAt.Before(staleBefore))
+			if ready && (due == nil || stored.RunAfter.Before(due.RunAfter)) {
+				deletion := stored
+				due = &deletion
+			}
+		}
+		if due == nil {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return due, err
+}
+
+func (r memoryAccountDeletions) Update(deletion *models.AccountDeletion) error {
+	deletion.UpdatedAt = time.Now()
+	return r.m.run(func(s *memoryState) error {
+		if _, ok := s.accountDeletions[deletion.ID]; ok {
+			s.accountDeletions[deletion.ID] = *deletion
+		}
+		return nil
+	})
+}
+
+func (r memoryAccountDeletions) CancelScheduled(userID int, at time.Time) error {
+	return r.m.run(func(s *memoryState) error {
+		canceled := false
+		for id, deletion := range s.accountDeletions {
+			if deletion.UserID == userID && deletion.Status == models.DeletionScheduled {
+				deletion.Status = models.DeletionCanceled
+				deletion.FinishedAt = &at
+				deletion.UpdatedAt = at
+				s.accountDeletions[id] = deletion
+				canceled = true
+			}
+		}
+		if !canceled {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
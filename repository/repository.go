@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"apiForSN/models"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound возвращается, если запись не найдена
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate возвращается при нарушении уникальности: занятый email, повторный лайк
+	ErrDuplicate = errors.New("duplicate record")
+	// ErrForeignKey возвращается, если запись ссылается на несуществующую
+	ErrForeignKey = errors.New("foreign key violation")
+	// ErrCheck возвращается при нарушении CHECK-ограничения, например у лайка нет цели
+	ErrCheck = errors.New("check constraint violation")
+	// ErrVersionConflict возвращается, если запись изменили после того, как её прочитали
+	ErrVersionConflict = errors.New("version conflict")
+)
+
+// Имена ограничений уникальности, по которым обработчики отличают занятый email от занятого никнейма
+const (
+	ConstraintUserEmail    = "users_email_key"
+	ConstraintUserNickname = "users_nickname_key"
+)
+
+// ConstraintError — нарушение ограничения схемы. Err — ErrDuplicate, ErrForeignKey или ErrCheck,
+// Constraint — имя ограничения, если хранилище его сообщило
+type ConstraintError struct {
+	Err        error
+	Constraint string
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Constraint == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Constraint
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// ViolatedConstraint возвращает имя нарушенного ограничения или пустую строку
+func ViolatedConstraint(err error) string {
+	var constraintErr *ConstraintError
+	if errors.As(err, &constraintErr) {
+		return constraintErr.Constraint
+	}
+	return ""
+}
+
+// Поля, которые можно передать в Update. Имена совпадают с колонками таблиц
+const (
+	FieldNickname      = "nickname"
+	FieldEmail         = "email"
+	FieldPassword      = "password"
+	FieldEmailVerified = "email_verified"
+	FieldTOTPSecret    = "totp_secret"
+	FieldTOTPEnabled   = "totp_enabled"
+	FieldTOTPLastStep  = "totp_last_step"
+	FieldRoles         = "roles"
+	FieldContent       = "content"
+	FieldEditedAt      = "edited_at"
+)
+
+// Пользователь-заглушка, которому передаются посты и комментарии анонимизированных аккаунтов. Он создаётся
+// миграцией 0005_account_deletions (в памяти — при создании хранилища), его ник и email зарезервированы и не
+// могут быть заняты при регистрации. Роль через API назначить нельзя, пустой хеш пароля не совпадает
+// ни с одним паролем, поэтому войти под заглушкой нельзя
+const (
+	TombstoneRole     = "tombstone"
+	TombstoneNickname = "[deleted]"
+	TombstoneEmail    = "deleted@tombstone.invalid"
+)
+
+// UserRepository хранит пользователей. Email сравнивается без учёта регистра
+type UserRepository interface {
+	Create(user *models.User) error
+	// GetByID и GetByEmail не возвращают удалённых пользователей
+	GetByID(id int) (*models.User, error)
+	GetByEmail(email string) (*models.User, error)
+	// EmailTaken сообщает, занят ли email другим пользователем, кроме exceptID.
+	// Email удалённого пользователя остаётся занятым до окончательной очистки
+	EmailTaken(email string, exceptID int) (bool, error)
+	// Update сохраняет перечисленные поля пользователя и обновляет UpdatedAt
+	Update(user *models.User, fields ...string) error
+	// ReplacePassword меняет хеш пароля, только если он всё ещё равен oldHash
+	ReplacePassword(id int, oldHash, newHash string) (bool, error)
+	// AdvanceTOTPStep запоминает принятый интервал TOTP, только если он новее сохранённого
+	AdvanceTOTPStep(id int, step int64) (bool, error)
+	// SoftDelete помечает пользователя удалённым. ErrNotFound, если активного пользователя нет
+	SoftDelete(id int, at time.Time) error
+	// GetDeletedByEmail находит пользователя, который помечен удалённым, но ещё не очищен
+	GetDeletedByEmail(email string) (*models.User, error)
+	// Restore снимает пометку об удалении. ErrNotFound, если удалённого пользователя нет
+	Restore(id int) error
+	// Delete окончательно удаляет пользователя вместе со всеми данными, которые на него ссылаются
+	Delete(id int) error
+	// Tombstone возвращает пользователя-заглушку с ролью TombstoneRole или ErrNotFound, если миграция не применена
+	Tombstone() (*models.User, error)
+}
+
+// PostRepository хранит посты
+type PostRepository interface {
+	Create(post *models.Post) error
+	// GetByID и LockByID не возвращают удалённые посты и посты удалённых пользователей
+	GetByID(id int) (*models.Post, error)
+	// LockByID находит пост и блокирует его строку до конца транзакции
+	LockByID(id int) (*models.Post, error)
+	// Update сохраняет перечисленные поля поста, обновляет UpdatedAt и увеличивает Version.
+	// Если версия в хранилище уже не совпадает с post.Version, возвращает ErrVersionConflict
+	Update(post *models.Post, fields ...string) error
+	// SoftDelete помечает пост удалённым и запоминает, кто его удалил. ErrNotFound, если поста нет или он уже удалён
+	SoftDelete(id, deletedBy int, at time.Time) error
+	// GetDeleted находит пост, который помечен удалённым, но ещё не очищен
+	GetDeleted(id int) (*models.Post, error)
+	// Restore снимает пометку об удалении. ErrNotFound, если удалённого поста нет
+	Restore(id int) error
+	// Purge окончательно удаляет посты, помеченные удалёнными раньше deletedBefore, вместе с их комментариями
+	Purge(deletedBefore time.Time) (int64, error)
+	// Delete окончательно удаляет пост вместе с его комментариями и лайками
+	Delete(id int) error
+	// CountByUser и ListByUser учитывают все посты пользователя, в том числе удалённые. ListByUser возвращает первые limit по ID
+	CountByUser(userID int) (int64, error)
+	ListByUser(userID int, limit int) ([]models.Post, error)
+	// Reassign передаёт до limit постов пользователя fromUserID пользователю toUserID и возвращает их число
+	Reassign(fromUserID, toUserID int, limit int) (int64, error)
+	// AddLikes и AddComments изменяют счётчики на delta
+	AddLikes(id int, delta int) error
+	AddComments(id int, delta int) error
+	// RecountCounters сверяет счётчики лайков и неудалённых комментариев с таблицами likes и comments и возвращает
+	// число постов с расхождением. Без dryRun счётчики исправляются. Вызывается внутри транзакции
+	RecountCounters(dryRun bool) (int64, error)
+}
+
+// CommentRepository хранит комментарии
+type CommentRepository interface {
+	Create(comment *models.Comment) error
+	// GetByID и LockByID не возвращают удалённые комментарии, а также комментарии удалённых постов и пользователей
+	GetByID(id int) (*models.Comment, error)
+	// LockByID находит комментарий и блокирует его строку до конца транзакции
+	LockByID(id int) (*models.Comment, error)
+	// Update сохраняет перечисленные поля комментария так же, как PostRepository.Update
+	Update(comment *models.Comment, fields ...string) error
+	// SoftDelete, GetDeleted, Restore, Purge, Delete, CountByUser, ListByUser и Reassign работают
+	// как одноимённые методы PostRepository
+	SoftDelete(id, deletedBy int, at time.Time) error
+	GetDeleted(id int) (*models.Comment, error)
+	Restore(id int) error
+	Purge(deletedBefore time.Time) (int64, error)
+	Delete(id int) error
+	CountByUser(userID int) (int64, error)
+	ListByUser(userID int, limit int) ([]models.Comment, error)
+	Reassign(fromUserID, toUserID int, limit int) (int64, error)
+	// AddLikes изменяет счётчик лайков на delta
+	AddLikes(id int, delta int) error
+	// RecountCounters сверяет счётчики лайков с таблицей likes, как PostRepository.RecountCounters
+	RecountCounters(dryRun bool) (int64, error)
+}
+
+// LikeRepository хранит лайки постов и комментариев
+type LikeRepository interface {
+	FindPostLike(userID, postID int) (*models.Like, error)
+	FindCommentLike(userID, commentID int) (*models.Like, error)
+	Create(like *models.Like) error
+	Delete(id int) error
+	// CountByUser и ListByUser учитывают все лайки пользователя. ListByUser возвращает первые limit по ID
+	CountByUser(userID int) (int64, error)
+	ListByUser(userID int, limit int) ([]models.Like, error)
+}
+
+// AdminActionRepository хранит журнал действий администраторов и модераторов
+type AdminActionRepository interface {
+	Create(action *models.AdminAction) error
+	// List возвращает последние limit записей, начиная с новых
+	List(limit int) ([]models.AdminAction, error)
+}
+
+// APIKeyRepository хранит ключи API
+type APIKeyRepository interface {
+	Create(key *models.APIKey) error
+	// CountActive и ListActive учитывают только неотозванные ключи пользователя
+	CountActive(userID int) (int64, error)
+	ListActive(userID int) ([]models.APIKey, error)
+	// Revoke отзывает ключ пользователя. false, если активного ключа с таким ID нет
+	Revoke(id, userID int, at time.Time) (bool, error)
+	GetByHash(hash string) (*models.APIKey, error)
+	// TouchLastUsed запоминает время последнего использования ключа
+	TouchLastUsed(id int, at time.Time) error
+}
+
+// RecoveryCodeRepository хранит хеши кодов восстановления 2FA
+type RecoveryCodeRepository interface {
+	// Replace удаляет все коды пользователя и сохраняет новые
+	Replace(userID int, codes []models.RecoveryCode) error
+	ListUnused(userID int) ([]models.RecoveryCode, error)
+	// MarkUsed погашает код. false, если он уже использован
+	MarkUsed(id int, at time.Time) (bool, error)
+}
+
+// OneTimeTokenRepository хранит хеши одноразовых токенов из писем
+type OneTimeTokenRepository interface {
+	Create(token *models.OneTimeToken) error
+	// LockByHash находит токен и блокирует его до конца транзакции
+	LockByHash(hash, purpose string) (*models.OneTimeToken, error)
+	// InvalidateUnused погашает все неиспользованные токены пользователя с этим назначением
+	InvalidateUnused(userID int, purpose string, at time.Time) error
+}
+
+// RefreshTokenRepository хранит хеши refresh-токенов
+type RefreshTokenRepository interface {
+	Create(token *models.RefreshToken) error
+	// LockByHash находит токен и блокирует его до конца транзакции
+	LockByHash(hash string) (*models.RefreshToken, error)
+	GetByHash(hash string, userID int) (*models.RefreshToken, error)
+	MarkUsed(id int, at time.Time) error
+	// RevokeFamily и RevokeUser отзывают ещё не отозванные токены семейства или пользователя
+	RevokeFamily(familyID string, at time.Time) error
+	RevokeUser(userID int, at time.Time) error
+}
+
+// SessionRepository хранит сессии (входы с устройств)
+type SessionRepository interface {
+	Create(session *models.Session) error
+	// GetActive возвращает неотозванную сессию пользователя
+	GetActive(id string, userID int) (*models.Session, error)
+	// ListActive возвращает неотозванные сессии пользователя, активные после seenAfter
+	ListActive(userID int, seenAfter time.Time) ([]models.Session, error)
+	// Touch обновляет сведения о клиенте и время последней активности
+	Touch(id, userAgent, ip string, at time.Time) error
+	// UpdateLastSeen переносит время последней активности, только если оно новее сохранённого
+	UpdateLastSeen(id string, at time.Time) error
+	// Revoke и RevokeUser отзывают ещё не отозванную сессию или все сессии пользователя
+	Revoke(id string, at time.Time) error
+	RevokeUser(userID int, at time.Time) error
+}
+
+// RevocationRepository хранит отзывы access-токенов
+type RevocationRepository interface {
+	// RevokeToken отзывает токен по jti. Повторный отзыв не считается ошибкой
+	RevokeToken(token models.RevokedToken) error
+	// RevokeUserTokens отзывает токены пользователя без сессии, выпущенные не позже before
+	RevokeUserTokens(userID int, before time.Time) error
+	// IsRevoked проверяет отзыв самого токена и сессии, к которой привязан токен, а токена без сессии —
+	// ещё и отзыв всех токенов пользователя
+	IsRevoked(jti string, userID int, issuedAt time.Time, sessionID string) (bool, error)
+}
+
+// AccountDeletionRepository хранит задачи удаления аккаунтов
+type AccountDeletionRepository interface {
+	Create(deletion *models.AccountDeletion) error
+	GetByID(id string) (*models.AccountDeletion, error)
+	// LockDue находит запланированную задачу, время которой наступило к now, или выполняющуюся задачу,
+	// которая не обновлялась с staleBefore (процесс остановился посреди работы), и блокирует её до конца транзакции
+	LockDue(now, staleBefore time.Time) (*models.AccountDeletion, error)
+	// Update сохраняет состояние и прогресс задачи и обновляет UpdatedAt
+	Update(deletion *models.AccountDeletion) error
+	// CancelScheduled отменяет ещё не начатую задачу пользователя. ErrNotFound, если такой задачи нет
+	CancelScheduled(userID int, at time.Time) error
+	// ListUnscheduledUsers возвращает до limit удалённых пользователей без запланированной или выполняющейся задачи
+	ListUnscheduledUsers(limit int) ([]models.User, error)
+}
+
+// Repositories — набор репозиториев одного хранилища
+type Repositories struct {
+	Users            UserRepository
+	Posts            PostRepository
+	Comments         CommentRepository
+	Likes            LikeRepository
+	AdminActions     AdminActionRepository
+	APIKeys          APIKeyRepository
+	RecoveryCodes    RecoveryCodeRepository
+	OneTimeTokens    OneTimeTokenRepository
+	RefreshTokens    RefreshTokenRepository
+	Sessions         SessionRepository
+	Revocations      RevocationRepository
+	AccountDeletions AccountDeletionRepository
+
+	transaction func(fn func(tx Repositories) error) error
+}
+
+// Transaction выполняет fn с репозиториями, работающими в одной транзакции.
+// Если fn возвращает ошибку, все изменения откатываются
+func (r Repositories) Transaction(fn func(tx Repositories) error) error {
+	return r.transaction(fn)
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLite хранит время строкой вместе со смещением часового пояса, а в запросах сравнивает такие строки
+// посимвольно. Поэтому репозитории GORM записывают всё время в UTC: значения полей и колонок переводит
+// колбэк utcTimes, аргументы условий переводятся в UTC на месте
+const utcTimesCallback = "repository:utc_times"
+
+// registerUTCTimes подключает utcTimes перед вставкой и обновлением. Колбэки общие для соединения
+// и его транзакций, поэтому повторно они не регистрируются
+func registerUTCTimes(db *gorm.DB) {
+	if db.Callback().Create().Get(utcTimesCallback) != nil {
+		return
+	}
+	db.Callback().Create().Before("gorm:create").Register(utcTimesCallback, utcTimes)
+	db.Callback().Update().Before("gorm:update").Register(utcTimesCallback, utcTimes)
+}
+
+// utcTimes переводит в UTC время, которое запрос записывает: поля модели или значения карты колонок
+func utcTimes(db *gorm.DB) {
+	if columns, ok := db.Statement.Dest.(map[string]interface{}); ok {
+		for column, value := range columns {
+			switch t := value.(type) {
+			case time.Time:
+				columns[column] = t.UTC()
+			case *time.Time:
+				if t != nil {
+					columns[column] = t.UTC()
+				}
+			}
+		}
+		return
+	}
+	if db.Statement.Schema == nil {
+		return
+	}
+	value := db.Statement.ReflectValue
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			utcFields(db, reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		utcFields(db, value)
+	}
+}
+
+func utcFields(db *gorm.DB, record reflect.Value) {
+	ctx := db.Statement.Context
+	for _, field := range db.Statement.Schema.Fields {
+		value, zero := field.ValueOf(ctx, record)
+		if zero {
+			continue
+		}
+		switch t := value.(type) {
+		case time.Time:
+			db.AddError(field.Set(ctx, record, t.UTC()))
+		case *time.Time:
+			utc := t.UTC()
+			db.AddError(field.Set(ctx, record, &utc))
+		}
+	}
+}